@@ -0,0 +1,321 @@
+// Package intake provides HTTP routing utilities.
+// This file contains middleware that authenticates machine clients with API
+// keys, and the stores holding the hashes of those keys.
+package intake
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKey describes an issued API key. Only the SHA-256 hash of the key is
+// kept, so a leaked store does not reveal usable credentials.
+type APIKey struct {
+	// ID identifies the key itself, e.g. for revocation and logging.
+	ID string `json:"id"`
+	// ClientID identifies the client the key was issued to. A client may
+	// hold several active keys while rotating them.
+	ClientID string `json:"client_id"`
+	// Hash is the SHA-256 hash of the key, as returned by HashAPIKey.
+	Hash [sha256.Size]byte `json:"-"`
+	// Scopes are the permissions granted to requests made with the key.
+	Scopes []string `json:"scopes,omitempty"`
+	// ExpiresAt is when the key stops being accepted. Zero means never.
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// HashAPIKey returns the SHA-256 hash under which key is stored.
+func HashAPIKey(key string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(key))
+}
+
+// expired reports whether the key is past its expiry at now.
+func (k *APIKey) expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// APIKeyStore looks up API keys by hash. Implementations must be safe for
+// concurrent use.
+type APIKeyStore interface {
+	// Lookup returns the key whose hash equals hash, or nil if there is none.
+	// Expired keys may be returned; the middleware rejects them.
+	Lookup(ctx context.Context, hash [sha256.Size]byte) (*APIKey, error)
+}
+
+// matchAPIKey compares hash against every key in constant time, so the time
+// taken does not depend on which key, if any, matched.
+func matchAPIKey(keys []APIKey, hash [sha256.Size]byte) *APIKey {
+	var match *APIKey
+	for i := range keys {
+		if subtle.ConstantTimeCompare(keys[i].Hash[:], hash[:]) == 1 {
+			match = &keys[i]
+		}
+	}
+	if match == nil {
+		return nil
+	}
+	key := *match
+	key.Scopes = slices.Clone(match.Scopes)
+	return &key
+}
+
+// MemoryAPIKeyStore is an in-memory APIKeyStore.
+type MemoryAPIKeyStore struct {
+	mu   sync.RWMutex
+	keys []APIKey
+}
+
+// NewMemoryAPIKeyStore creates a MemoryAPIKeyStore holding keys.
+func NewMemoryAPIKeyStore(keys ...APIKey) *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{keys: slices.Clone(keys)}
+}
+
+// Add stores key, replacing any key with the same ID.
+func (s *MemoryAPIKeyStore) Add(key APIKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = slices.DeleteFunc(s.keys, func(k APIKey) bool { return k.ID == key.ID })
+	s.keys = append(s.keys, key)
+}
+
+// Revoke removes the key with the given ID.
+func (s *MemoryAPIKeyStore) Revoke(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = slices.DeleteFunc(s.keys, func(k APIKey) bool { return k.ID == id })
+}
+
+// Lookup returns the key whose hash equals hash.
+func (s *MemoryAPIKeyStore) Lookup(ctx context.Context, hash [sha256.Size]byte) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return matchAPIKey(s.keys, hash), nil
+}
+
+// FileAPIKeyStore is an APIKeyStore loaded from a JSON file. The file holds
+// an array of objects with the fields of APIKey and the key hash as a hex
+// string, e.g.
+//
+//	[{"id": "k1", "client_id": "billing", "hash": "9f86d0...", "scopes": ["invoices:read"]}]
+//
+// The file is checked for changes at most once per reload interval and
+// reloaded when its modification time or size changes. If a reload fails,
+// the previously loaded keys stay in use.
+type FileAPIKeyStore struct {
+	path   string
+	reload time.Duration
+
+	mu      sync.Mutex
+	keys    []APIKey
+	modTime time.Time
+	size    int64
+	checked time.Time
+}
+
+// NewFileAPIKeyStore loads the keys in the file at path.
+//
+// Parameters:
+//   - path: The location of the JSON key file
+//   - reload: How often the file is checked for changes
+//
+// Returns:
+//   - The loaded store, or an error if the file cannot be read or parsed
+func NewFileAPIKeyStore(path string, reload time.Duration) (*FileAPIKeyStore, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := readAPIKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAPIKeyStore{
+		path:    path,
+		reload:  reload,
+		keys:    keys,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		checked: time.Now(),
+	}, nil
+}
+
+// Lookup returns the key whose hash equals hash, reloading the file first if
+// it has changed. The file is read without holding the lock, so lookups by
+// other requests use the previous keys meanwhile.
+func (s *FileAPIKeyStore) Lookup(ctx context.Context, hash [sha256.Size]byte) (*APIKey, error) {
+	s.mu.Lock()
+	keys := s.keys
+	due := time.Since(s.checked) >= s.reload
+	if due {
+		// Claim the check so concurrent lookups do not read the file too.
+		s.checked = time.Now()
+	}
+	modTime, size := s.modTime, s.size
+	s.mu.Unlock()
+
+	if due {
+		if info, err := os.Stat(s.path); err == nil && (!info.ModTime().Equal(modTime) || info.Size() != size) {
+			if loaded, err := readAPIKeyFile(s.path); err == nil {
+				s.mu.Lock()
+				s.keys = loaded
+				s.modTime = info.ModTime()
+				s.size = info.Size()
+				s.mu.Unlock()
+				keys = loaded
+			}
+		}
+	}
+	return matchAPIKey(keys, hash), nil
+}
+
+// readAPIKeyFile parses the key file at path.
+func readAPIKeyFile(path string) ([]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []struct {
+		APIKey
+		Hash string `json:"hash"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parsing API key file: %w", err)
+	}
+
+	keys := make([]APIKey, 0, len(entries))
+	for _, entry := range entries {
+		hash, err := hex.DecodeString(entry.Hash)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("parsing API key file: key %q has an invalid hash", entry.ID)
+		}
+		key := entry.APIKey
+		copy(key.Hash[:], hash)
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+type apiKeyContextKey struct{}
+
+// APIKeyFromContext returns the key placed on the context by the APIKeyAuth
+// middleware.
+func APIKeyFromContext(ctx context.Context) (*APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey{}).(*APIKey)
+	return key, ok
+}
+
+// APIKeyConfig defines the configuration options for the APIKeyAuth middleware.
+type APIKeyConfig struct {
+	// Store holds the issued keys.
+	Store APIKeyStore
+
+	// Header is the request header carrying the key. Default value is
+	// "X-API-Key". An "Authorization: ApiKey <key>" header is also accepted.
+	Header string
+
+	// QueryParam, if set, names a query parameter the key may be sent in.
+	// Query strings tend to end up in logs, so prefer a header.
+	QueryParam string
+
+	// BasicAuth accepts the key as the password of HTTP Basic credentials.
+	BasicAuth bool
+
+	// Realm is reported in the WWW-Authenticate header of 401 responses
+	// when BasicAuth is enabled.
+	Realm string
+}
+
+// DefaultAPIKeyConfig returns an API key configuration with common settings.
+// The default configuration:
+// - Reads the key from the X-API-Key header
+// - Does not accept keys in the query string or Basic credentials
+func DefaultAPIKeyConfig() APIKeyConfig {
+	return APIKeyConfig{
+		Header: "X-API-Key",
+	}
+}
+
+// APIKeyAuth returns a middleware that requires a valid API key. The key is
+// hashed and looked up in the configured store; unknown and expired keys are
+// rejected with 401 Unauthorized. The matched key is available through
+// APIKeyFromContext, and its client and scopes through PrincipalFromContext,
+// so routes can require scopes with WithScopes.
+//
+// Parameters:
+//   - config: The APIKeyConfig struct containing the API key configuration
+//
+// Returns:
+//   - A MiddleWare function that can be applied to HTTP handlers
+func APIKeyAuth(config APIKeyConfig) MiddleWare {
+	// A nil *FileAPIKeyStore, e.g. from ignoring the error of
+	// NewFileAPIKeyStore, would otherwise only fail on the first request.
+	if v := reflect.ValueOf(config.Store); !v.IsValid() || (v.Kind() == reflect.Pointer && v.IsNil()) {
+		panic("intake: APIKeyAuth requires a Store")
+	}
+	if config.Header == "" {
+		config.Header = DefaultAPIKeyConfig().Header
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			raw := apiKeyFromRequest(r, config)
+			if raw == "" {
+				apiKeyUnauthorized(w, config)
+				return
+			}
+
+			key, err := config.Store.Lookup(r.Context(), HashAPIKey(raw))
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if key == nil || key.expired(time.Now()) {
+				apiKeyUnauthorized(w, config)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), apiKeyContextKey{}, key)
+			ctx = WithPrincipal(ctx, &Principal{Subject: key.ClientID, Scopes: key.Scopes})
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// apiKeyFromRequest returns the key sent with r, or "" if there is none.
+func apiKeyFromRequest(r *http.Request, config APIKeyConfig) string {
+	if key := r.Header.Get(config.Header); key != "" {
+		return key
+	}
+	if scheme, key, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "ApiKey") {
+		return strings.TrimSpace(key)
+	}
+	if config.BasicAuth {
+		if _, password, ok := r.BasicAuth(); ok && password != "" {
+			return password
+		}
+	}
+	if config.QueryParam != "" {
+		return r.URL.Query().Get(config.QueryParam)
+	}
+	return ""
+}
+
+// apiKeyUnauthorized writes a 401 response, with a Basic challenge when
+// Basic credentials are accepted.
+func apiKeyUnauthorized(w http.ResponseWriter, config APIKeyConfig) {
+	if config.BasicAuth {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", config.Realm))
+	}
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
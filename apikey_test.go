@@ -0,0 +1,136 @@
+package intake
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	store := NewMemoryAPIKeyStore(
+		APIKey{ID: "k1", ClientID: "billing", Hash: HashAPIKey("old-key"), Scopes: []string{"invoices:read"}},
+		APIKey{ID: "k2", ClientID: "billing", Hash: HashAPIKey("new-key"), Scopes: []string{"invoices:read"}},
+		APIKey{ID: "k3", ClientID: "legacy", Hash: HashAPIKey("expired-key"), ExpiresAt: time.Now().Add(-time.Hour)},
+	)
+
+	app := New()
+	app.AddEndpoints(Endpoints{
+		GET("/invoices", func(w http.ResponseWriter, r *http.Request) {
+			key, _ := APIKeyFromContext(r.Context())
+			p, _ := PrincipalFromContext(r.Context())
+			w.Write([]byte(key.ID + ":" + p.Subject))
+		}, APIKeyAuth(APIKeyConfig{Store: store, QueryParam: "api_key", BasicAuth: true, Realm: "api"})).With(WithScopes("invoices:read")),
+	})
+
+	call := func(setup func(r *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/invoices", nil)
+		setup(req)
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	accepted := map[string]struct {
+		setup func(r *http.Request)
+		want  string
+	}{
+		"header":        {func(r *http.Request) { r.Header.Set("X-API-Key", "old-key") }, "k1:billing"},
+		"rotated key":   {func(r *http.Request) { r.Header.Set("X-API-Key", "new-key") }, "k2:billing"},
+		"authorization": {func(r *http.Request) { r.Header.Set("Authorization", "ApiKey new-key") }, "k2:billing"},
+		"basic auth":    {func(r *http.Request) { r.SetBasicAuth("billing", "old-key") }, "k1:billing"},
+		"query":         {func(r *http.Request) { r.URL.RawQuery = "api_key=new-key" }, "k2:billing"},
+	}
+	for name, tc := range accepted {
+		rr := call(tc.setup)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", name, http.StatusOK, rr.Code)
+		}
+		if got := rr.Body.String(); got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", name, tc.want, got)
+		}
+	}
+
+	rejected := map[string]func(r *http.Request){
+		"missing": func(r *http.Request) {},
+		"unknown": func(r *http.Request) { r.Header.Set("X-API-Key", "nope") },
+		"expired": func(r *http.Request) { r.Header.Set("X-API-Key", "expired-key") },
+	}
+	for name, setup := range rejected {
+		rr := call(setup)
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected status %d, got %d", name, http.StatusUnauthorized, rr.Code)
+		}
+		if got := rr.Header().Get("WWW-Authenticate"); got != `Basic realm="api"` {
+			t.Fatalf("%s: unexpected challenge %q", name, got)
+		}
+	}
+
+	store.Revoke("k1")
+	if rr := call(func(r *http.Request) { r.Header.Set("X-API-Key", "old-key") }); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked key to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestFileAPIKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	write := func(entries ...string) {
+		t.Helper()
+		data := "[" + strings.Join(entries, ",") + "]"
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatalf("failed to write key file: %v", err)
+		}
+	}
+	entry := func(id, key string) string {
+		hash := HashAPIKey(key)
+		return fmt.Sprintf(`{"id":%q,"client_id":"svc","hash":%q,"scopes":["read"]}`, id, hex.EncodeToString(hash[:]))
+	}
+
+	write(entry("k1", "first"))
+	store, err := NewFileAPIKeyStore(path, 0)
+	if err != nil {
+		t.Fatalf("failed to load store: %v", err)
+	}
+
+	key, err := store.Lookup(t.Context(), HashAPIKey("first"))
+	if err != nil || key == nil || key.ID != "k1" || key.ClientID != "svc" || key.Scopes[0] != "read" {
+		t.Fatalf("unexpected lookup result %+v, %v", key, err)
+	}
+
+	// Rotate: add a second key, then drop the first.
+	write(entry("k1", "first"), entry("k2", "second"))
+	if key, _ := store.Lookup(t.Context(), HashAPIKey("second")); key == nil || key.ID != "k2" {
+		t.Fatalf("expected new key after reload, got %+v", key)
+	}
+	write(entry("k2", "second"))
+	if key, _ := store.Lookup(t.Context(), HashAPIKey("first")); key != nil {
+		t.Fatalf("expected retired key to be removed, got %+v", key)
+	}
+
+	// A broken file keeps the previous keys.
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+	if key, _ := store.Lookup(t.Context(), HashAPIKey("second")); key == nil {
+		t.Fatalf("expected previous keys to survive a bad reload")
+	}
+
+	if _, err := NewFileAPIKeyStore(path, time.Minute); err == nil {
+		t.Fatalf("expected an error loading an invalid file")
+	}
+}
+
+func TestAPIKeyAuthNilStore(t *testing.T) {
+	var store *FileAPIKeyStore
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected APIKeyAuth to panic on a nil store")
+		}
+	}()
+	APIKeyAuth(APIKeyConfig{Store: store})
+}
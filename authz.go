@@ -0,0 +1,119 @@
+// Package intake provides HTTP routing utilities.
+// This file contains the authenticated principal shared by the authentication
+// middleware and the route-level authorization built on top of it.
+package intake
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Principal is the authenticated identity making a request. Authentication
+// middleware such as JWT place it on the request context, and routes that
+// require scopes or roles are authorized against it.
+type Principal struct {
+	// Subject identifies the user or client.
+	Subject string
+	// Scopes are the permissions granted to the credentials.
+	Scopes []string
+	// Roles are the roles held by the subject.
+	Roles []string
+}
+
+// HasScopes reports whether the principal holds every one of scopes.
+func (p *Principal) HasScopes(scopes ...string) bool {
+	for _, scope := range scopes {
+		if !slices.Contains(p.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasAnyRole reports whether the principal holds at least one of roles, or
+// true if roles is empty.
+func (p *Principal) HasAnyRole(roles ...string) bool {
+	if len(roles) == 0 {
+		return true
+	}
+	for _, role := range roles {
+		if slices.Contains(p.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p. Custom authentication
+// middleware should use it so route requirements can be enforced.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal placed on ctx by an
+// authentication middleware.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// WithScopes requires the authenticated principal to hold every one of
+// scopes to call the route.
+//
+// Parameters:
+//   - scopes: The scopes required by the route
+func WithScopes(scopes ...string) EndpointOption {
+	return func(o *routeOptions) {
+		o.scopes = appendUnique(o.scopes, scopes...)
+	}
+}
+
+// WithRoles requires the authenticated principal to hold at least one of
+// roles to call the route.
+//
+// Parameters:
+//   - roles: The roles accepted by the route
+func WithRoles(roles ...string) EndpointOption {
+	return func(o *routeOptions) {
+		o.roles = appendUnique(o.roles, roles...)
+	}
+}
+
+// authorizeRoute returns a middleware enforcing the scope and role
+// requirements in opts against the principal on the request context. The
+// router installs it innermost, after all authentication middleware has run.
+func authorizeRoute(opts *routeOptions) MiddleWare {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			p, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			if !p.HasScopes(opts.scopes...) || !p.HasAnyRole(opts.roles...) {
+				if len(opts.scopes) > 0 {
+					w.Header().Set("WWW-Authenticate",
+						`Bearer error="insufficient_scope", scope="`+strings.Join(opts.scopes, " ")+`"`)
+				}
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// appendUnique appends the values not already present in s.
+func appendUnique(s []string, values ...string) []string {
+	s = slices.Clone(s)
+	for _, v := range values {
+		if !slices.Contains(s, v) {
+			s = append(s, v)
+		}
+	}
+	return s
+}
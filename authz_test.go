@@ -0,0 +1,126 @@
+package intake
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouteAuthorization(t *testing.T) {
+	// authenticate sets a principal from test headers, standing in for a
+	// real authentication middleware.
+	authenticate := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if sub := r.Header.Get("X-Subject"); sub != "" {
+				p := &Principal{
+					Subject: sub,
+					Scopes:  strings.Fields(r.Header.Get("X-Scopes")),
+					Roles:   strings.Fields(r.Header.Get("X-Roles")),
+				}
+				r = r.WithContext(WithPrincipal(r.Context(), p))
+			}
+			next(w, r)
+		}
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}
+
+	app := New()
+	orders := Endpoints{
+		GET("/orders", ok),
+		POST("/orders", ok).With(WithScopes("orders:write")),
+	}
+	orders.Use(authenticate)
+	orders.Require("orders:read")
+	app.AddEndpoints(orders)
+	app.AddEndpoint(http.MethodDelete, "/orders/{id}", ok, authenticate)
+	app.AddEndpoints(Endpoints{
+		NewEndpoint(http.MethodGet, "/admin", ok, authenticate).With(WithRoles("admin", "support")),
+		GET("/health", ok),
+	})
+
+	call := func(method, path, subject, scopes, roles string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if subject != "" {
+			req.Header.Set("X-Subject", subject)
+			req.Header.Set("X-Scopes", scopes)
+			req.Header.Set("X-Roles", roles)
+		}
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	cases := []struct {
+		name, method, path, subject, scopes, roles string
+		want                                       int
+	}{
+		{"no principal", http.MethodGet, "/orders", "", "", "", http.StatusUnauthorized},
+		{"missing scope", http.MethodGet, "/orders", "u1", "profile", "", http.StatusForbidden},
+		{"has scope", http.MethodGet, "/orders", "u1", "orders:read", "", http.StatusOK},
+		{"needs all scopes", http.MethodPost, "/orders", "u1", "orders:read", "", http.StatusForbidden},
+		{"has all scopes", http.MethodPost, "/orders", "u1", "orders:write orders:read", "", http.StatusOK},
+		{"no role", http.MethodGet, "/admin", "u1", "", "user", http.StatusForbidden},
+		{"any role", http.MethodGet, "/admin", "u1", "", "user support", http.StatusOK},
+		{"open route", http.MethodGet, "/health", "", "", "", http.StatusOK},
+		{"no requirements", http.MethodDelete, "/orders/1", "", "", "", http.StatusOK},
+	}
+	for _, tc := range cases {
+		rr := call(tc.method, tc.path, tc.subject, tc.scopes, tc.roles)
+		if rr.Code != tc.want {
+			t.Errorf("%s: expected status %d, got %d", tc.name, tc.want, rr.Code)
+		}
+	}
+
+	rr := call(http.MethodPost, "/orders", "u1", "orders:read", "")
+	if got := rr.Header().Get("WWW-Authenticate"); got != `Bearer error="insufficient_scope", scope="orders:write orders:read"` {
+		t.Errorf("unexpected challenge %q", got)
+	}
+
+	want := []RouteInfo{
+		{Method: http.MethodGet, Path: "/admin", Roles: []string{"admin", "support"}},
+		{Method: http.MethodGet, Path: "/health"},
+		{Method: http.MethodGet, Path: "/orders", Scopes: []string{"orders:read"}},
+		{Method: http.MethodPost, Path: "/orders", Scopes: []string{"orders:write", "orders:read"}},
+		{Method: http.MethodDelete, Path: "/orders/{id}"},
+	}
+	if got := app.Routes(); !slices.EqualFunc(got, want, func(x, y RouteInfo) bool {
+		return x.Method == y.Method && x.Path == y.Path &&
+			slices.Equal(x.Scopes, y.Scopes) && slices.Equal(x.Roles, y.Roles)
+	}) {
+		t.Errorf("unexpected routes %+v", got)
+	}
+}
+
+func TestJWTPrincipal(t *testing.T) {
+	secret := []byte("super-secret")
+	app := New()
+	app.AddEndpoints(Endpoints{
+		GET("/me", func(w http.ResponseWriter, r *http.Request) {
+			p, _ := PrincipalFromContext(r.Context())
+			w.Write([]byte(p.Subject + ":" + strings.Join(p.Roles, ",")))
+		}, JWT(JWTConfig{Keys: StaticKeySet{"k": secret}})).With(WithScopes("orders:read"), WithRoles("admin")),
+	})
+
+	token := signTestJWT(t, "HS256", "k", secret, map[string]any{
+		"sub":   "user-1",
+		"scope": "orders:read",
+		"roles": []string{"admin"},
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	})
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	app.Mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if got := rr.Body.String(); got != "user-1:admin" {
+		t.Fatalf("unexpected principal %q", got)
+	}
+}
@@ -0,0 +1,257 @@
+// Package intake provides HTTP routing utilities.
+// This file contains middleware that limits request body sizes and
+// transparently decodes compressed request bodies.
+package intake
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// BodyLimitConfig defines the configuration options for the BodyLimit middleware.
+type BodyLimitConfig struct {
+	// MaxBytes is the maximum size of the request body as sent by the client,
+	// before any decoding. Zero means no limit.
+	MaxBytes int64
+
+	// MaxDecompressedBytes is the maximum size of a compressed request body
+	// after decoding. It protects against decompression bombs. Zero means no limit.
+	MaxDecompressedBytes int64
+
+	// Decompress enables decoding of request bodies sent with a gzip or deflate
+	// Content-Encoding. Other encodings are rejected with 415 Unsupported Media Type.
+	Decompress bool
+}
+
+// DefaultBodyLimitConfig returns a body limit configuration with common settings.
+// The default configuration:
+// - Limits request bodies to 1 MiB on the wire
+// - Limits decoded bodies to 10 MiB
+// - Decodes gzip and deflate request bodies
+func DefaultBodyLimitConfig() BodyLimitConfig {
+	return BodyLimitConfig{
+		MaxBytes:             1 << 20,
+		MaxDecompressedBytes: 10 << 20,
+		Decompress:           true,
+	}
+}
+
+// BodyLimit returns a middleware that enforces request body size limits and,
+// if enabled, decodes compressed request bodies before the handler reads them.
+//
+// Requests whose Content-Length exceeds MaxBytes are rejected up front. Bodies
+// that exceed a limit while being read return an *http.MaxBytesError to the
+// handler, and any error response the handler then writes is replaced with a
+// consistent 413 Request Entity Too Large. Routes can set their own limits
+// with WithBodyLimit.
+//
+// Parameters:
+//   - config: The BodyLimitConfig struct containing the limits to enforce
+//
+// Returns:
+//   - A MiddleWare function that can be applied to HTTP handlers
+func BodyLimit(config BodyLimitConfig) MiddleWare {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		limited := bodyLimitHandler(next, config)
+		return func(w http.ResponseWriter, r *http.Request) {
+			// Routes with their own limits are handled by the router.
+			if opts := routeOptionsFrom(r); opts != nil && opts.bodyLimit != nil {
+				next(w, r)
+				return
+			}
+			limited(w, r)
+		}
+	}
+}
+
+// WithBodyLimit sets request body limits for a single route, overriding any
+// BodyLimit middleware. Compressed bodies are decoded for the route.
+//
+// Parameters:
+//   - maxBytes: The maximum body size before decoding, or zero for no limit
+//   - maxDecompressedBytes: The maximum body size after decoding, or zero for no limit
+func WithBodyLimit(maxBytes, maxDecompressedBytes int64) EndpointOption {
+	return func(o *routeOptions) {
+		o.bodyLimit = &BodyLimitConfig{
+			MaxBytes:             maxBytes,
+			MaxDecompressedBytes: maxDecompressedBytes,
+			Decompress:           true,
+		}
+	}
+}
+
+// bodyLimitHandler applies config to the request body before calling next.
+func bodyLimitHandler(next http.HandlerFunc, config BodyLimitConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.MaxBytes > 0 && r.ContentLength > config.MaxBytes {
+			respondTooLarge(w)
+			return
+		}
+
+		lw := &bodyLimitWriter{ResponseWriter: w}
+		body := r.Body
+		if config.MaxBytes > 0 {
+			body = http.MaxBytesReader(w, body, config.MaxBytes)
+		}
+
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		if config.Decompress && encoding != "" && encoding != "identity" && r.ContentLength != 0 {
+			var decoded io.ReadCloser
+			var err error
+			switch encoding {
+			case "gzip", "x-gzip":
+				decoded, err = gzip.NewReader(&bodyLimitReader{r: body, w: lw})
+			case "deflate":
+				decoded, err = zlib.NewReader(&bodyLimitReader{r: body, w: lw})
+			default:
+				w.Header().Set("Accept-Encoding", "gzip, deflate")
+				http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+				return
+			}
+			if err != nil {
+				if lw.exceeded {
+					respondTooLarge(w)
+					return
+				}
+				http.Error(w, "invalid request body encoding", http.StatusBadRequest)
+				return
+			}
+
+			if config.MaxDecompressedBytes > 0 {
+				decoded = &decodedLimitReader{
+					ReadCloser: decoded,
+					remaining:  config.MaxDecompressedBytes,
+					limit:      config.MaxDecompressedBytes,
+				}
+			}
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+			body = &closeBoth{ReadCloser: decoded, underlying: body}
+		}
+		r.Body = &bodyLimitReader{r: body, w: lw}
+
+		next(lw, r)
+
+		if lw.exceeded && !lw.wroteHeader {
+			respondTooLarge(w)
+		}
+	}
+}
+
+// respondTooLarge writes the standard 413 response used by BodyLimit.
+func respondTooLarge(w http.ResponseWriter) {
+	http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+}
+
+// bodyLimitWriter replaces the handler's error response with a 413 once the
+// request body has exceeded a limit.
+type bodyLimitWriter struct {
+	http.ResponseWriter
+	exceeded    bool
+	wroteHeader bool
+	suppressed  bool
+}
+
+// WriteHeader sends code, or a 413 if the body limit was hit and code is an error.
+func (lw *bodyLimitWriter) WriteHeader(code int) {
+	if lw.wroteHeader {
+		return
+	}
+	lw.wroteHeader = true
+	if lw.exceeded && code >= http.StatusBadRequest {
+		lw.suppressed = true
+		respondTooLarge(lw.ResponseWriter)
+		return
+	}
+	lw.ResponseWriter.WriteHeader(code)
+}
+
+// Write sends data unless the handler's error response has been replaced.
+func (lw *bodyLimitWriter) Write(data []byte) (int, error) {
+	if !lw.wroteHeader {
+		lw.WriteHeader(http.StatusOK)
+	}
+	if lw.suppressed {
+		return len(data), nil
+	}
+	return lw.ResponseWriter.Write(data)
+}
+
+// Flush flushes the underlying writer if it supports flushing.
+func (lw *bodyLimitWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (lw *bodyLimitWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+// bodyLimitReader records on w when reading r fails because of a size limit.
+type bodyLimitReader struct {
+	r io.ReadCloser
+	w *bodyLimitWriter
+}
+
+func (br *bodyLimitReader) Read(p []byte) (int, error) {
+	n, err := br.r.Read(p)
+	var maxErr *http.MaxBytesError
+	if err != nil && errors.As(err, &maxErr) {
+		br.w.exceeded = true
+	}
+	return n, err
+}
+
+func (br *bodyLimitReader) Close() error {
+	return br.r.Close()
+}
+
+// decodedLimitReader fails with an *http.MaxBytesError once more than limit
+// decoded bytes have been read.
+type decodedLimitReader struct {
+	io.ReadCloser
+	remaining int64
+	limit     int64
+}
+
+func (dr *decodedLimitReader) Read(p []byte) (int, error) {
+	if dr.remaining <= 0 {
+		// Probe for one more byte to tell an exact fit from an overflow.
+		var probe [1]byte
+		n, err := io.ReadFull(dr.ReadCloser, probe[:])
+		if n > 0 {
+			return 0, &http.MaxBytesError{Limit: dr.limit}
+		}
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return 0, err
+	}
+	if int64(len(p)) > dr.remaining {
+		p = p[:dr.remaining]
+	}
+	n, err := dr.ReadCloser.Read(p)
+	dr.remaining -= int64(n)
+	return n, err
+}
+
+// closeBoth closes a decoder and the body it reads from.
+type closeBoth struct {
+	io.ReadCloser
+	underlying io.Closer
+}
+
+func (c *closeBoth) Close() error {
+	err := c.ReadCloser.Close()
+	if cerr := c.underlying.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package intake
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestBodyLimit(t *testing.T) {
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write(body)
+	}
+
+	app := New()
+	app.AddGlobalMiddleware(BodyLimit(BodyLimitConfig{
+		MaxBytes:             64,
+		MaxDecompressedBytes: 128,
+		Decompress:           true,
+	}))
+	app.AddEndpoints(Endpoints{
+		POST("/echo", echo),
+		POST("/upload", echo).With(WithBodyLimit(1024, 4096)),
+	})
+
+	t.Run("decodes gzip bodies", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/echo", bytes.NewReader(gzipBytes(t, []byte("hello"))))
+		req.Header.Set("Content-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+		}
+		if got := rr.Body.String(); got != "hello" {
+			t.Fatalf("expected decoded body %q, got %q", "hello", got)
+		}
+	})
+
+	t.Run("rejects oversized Content-Length", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(strings.Repeat("a", 100)))
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("expected status %d, got %d", http.StatusRequestEntityTooLarge, rr.Code)
+		}
+	})
+
+	t.Run("stops decompression bombs", func(t *testing.T) {
+		bomb := gzipBytes(t, bytes.Repeat([]byte{0}, 10000))
+		if len(bomb) > 64 {
+			t.Fatalf("test payload too large: %d bytes", len(bomb))
+		}
+		req := httptest.NewRequest(http.MethodPost, "/echo", bytes.NewReader(bomb))
+		req.Header.Set("Content-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("expected status %d, got %d", http.StatusRequestEntityTooLarge, rr.Code)
+		}
+		if got := rr.Body.String(); got != "Request Entity Too Large\n" {
+			t.Fatalf("expected standard 413 body, got %q", got)
+		}
+	})
+
+	t.Run("route limits override the middleware", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(strings.Repeat("a", 500)))
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+		}
+	})
+
+	t.Run("rejects unsupported encodings", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("data"))
+		req.Header.Set("Content-Encoding", "br")
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusUnsupportedMediaType {
+			t.Fatalf("expected status %d, got %d", http.StatusUnsupportedMediaType, rr.Code)
+		}
+	})
+}
@@ -0,0 +1,471 @@
+// Package intake provides HTTP routing utilities.
+// This file contains an HTTP response cache middleware for idempotent routes
+// and the storage interface it uses.
+package intake
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheEntry is a stored response together with its freshness information.
+type CacheEntry struct {
+	// Status, Header and Body describe the stored response.
+	Status int
+	Header http.Header
+	Body   []byte
+
+	// Tags are the purge tags the response was stored with.
+	Tags []string
+
+	// StoredAt is when the response was generated.
+	StoredAt time.Time
+	// FreshUntil is when the response becomes stale.
+	FreshUntil time.Time
+	// StaleWhileRevalidate is how long after FreshUntil the stale response may
+	// be served while it is refreshed in the background.
+	StaleWhileRevalidate time.Duration
+	// StaleIfError is how long after FreshUntil the stale response may be
+	// served when the handler fails with a 5xx status.
+	StaleIfError time.Duration
+}
+
+// ExpiresAt returns the time after which the entry can no longer be served
+// in any form and may be discarded by the store.
+func (e *CacheEntry) ExpiresAt() time.Time {
+	return e.FreshUntil.Add(max(e.StaleWhileRevalidate, e.StaleIfError))
+}
+
+// size estimates the memory used by the entry in bytes.
+func (e *CacheEntry) size() int64 {
+	n := int64(len(e.Body))
+	for key, values := range e.Header {
+		n += int64(len(key))
+		for _, v := range values {
+			n += int64(len(v))
+		}
+	}
+	return n
+}
+
+// CacheStore is the storage backend used by ResponseCache. Implementations
+// must be safe for concurrent use.
+type CacheStore interface {
+	// Get returns the entry stored under key, if it has not expired.
+	Get(key string) (*CacheEntry, bool)
+	// Set stores entry under key, replacing any existing entry.
+	Set(key string, entry *CacheEntry)
+	// Delete removes the entry stored under key.
+	Delete(key string)
+	// DeleteTag removes every entry stored with tag.
+	DeleteTag(tag string)
+}
+
+// MemoryCacheStore is a size-bounded, in-memory CacheStore that evicts the
+// least recently used entries first and drops entries once they expire.
+type MemoryCacheStore struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+	tags    map[string]map[string]struct{}
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry *CacheEntry
+}
+
+// NewMemoryCacheStore creates a MemoryCacheStore holding at most maxBytes of
+// response data.
+func NewMemoryCacheStore(maxBytes int64) *MemoryCacheStore {
+	return &MemoryCacheStore{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		tags:     make(map[string]map[string]struct{}),
+	}
+}
+
+// Get returns the entry stored under key and marks it as recently used.
+func (s *MemoryCacheStore) Get(key string) (*CacheEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	item := elem.Value.(*memoryCacheItem)
+	if time.Now().After(item.entry.ExpiresAt()) {
+		s.remove(elem)
+		return nil, false
+	}
+	s.lru.MoveToFront(elem)
+	return item.entry, true
+}
+
+// Set stores entry under key, evicting old entries to stay within the size bound.
+func (s *MemoryCacheStore) Set(key string, entry *CacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.entries[key]; ok {
+		s.remove(elem)
+	}
+	size := entry.size()
+	if size > s.maxBytes {
+		return
+	}
+	s.entries[key] = s.lru.PushFront(&memoryCacheItem{key: key, entry: entry})
+	s.size += size
+	for _, tag := range entry.Tags {
+		if s.tags[tag] == nil {
+			s.tags[tag] = make(map[string]struct{})
+		}
+		s.tags[tag][key] = struct{}{}
+	}
+	for s.size > s.maxBytes {
+		s.remove(s.lru.Back())
+	}
+}
+
+// Delete removes the entry stored under key.
+func (s *MemoryCacheStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.entries[key]; ok {
+		s.remove(elem)
+	}
+}
+
+// DeleteTag removes every entry stored with tag.
+func (s *MemoryCacheStore) DeleteTag(tag string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.tags[tag] {
+		if elem, ok := s.entries[key]; ok {
+			s.remove(elem)
+		}
+	}
+	delete(s.tags, tag)
+}
+
+// Len returns the number of stored entries.
+func (s *MemoryCacheStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
+
+func (s *MemoryCacheStore) remove(elem *list.Element) {
+	item := s.lru.Remove(elem).(*memoryCacheItem)
+	delete(s.entries, item.key)
+	s.size -= item.entry.size()
+	for _, tag := range item.entry.Tags {
+		if keys := s.tags[tag]; keys != nil {
+			delete(keys, item.key)
+			if len(keys) == 0 {
+				delete(s.tags, tag)
+			}
+		}
+	}
+}
+
+// CacheConfig defines the configuration options for the response cache.
+type CacheConfig struct {
+	// Store holds cached responses. Default value is a MemoryCacheStore
+	// limited to 64 MiB.
+	Store CacheStore
+
+	// VaryHeaders lists request headers whose values are part of the cache
+	// key. Responses whose Vary names a header that is not listed here, such
+	// as the "Vary: Accept-Encoding" set by Compress, are never stored, nor
+	// are responses with "Vary: *".
+	VaryHeaders []string
+
+	// TagHeader is the response header from which purge tags are read as a
+	// comma separated list. Default value is "Cache-Tag".
+	TagHeader string
+}
+
+// DefaultCacheConfig returns a cache configuration with common settings.
+// The default configuration:
+// - Stores up to 64 MiB of responses in memory
+// - Reads purge tags from the Cache-Tag response header
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		Store:     NewMemoryCacheStore(64 << 20),
+		TagHeader: "Cache-Tag",
+	}
+}
+
+// ResponseCache caches responses of GET and HEAD routes according to the
+// Cache-Control headers set by their handlers.
+type ResponseCache struct {
+	config       CacheConfig
+	revalidating sync.Map
+}
+
+// NewResponseCache creates a ResponseCache from the given configuration.
+func NewResponseCache(config CacheConfig) *ResponseCache {
+	defaults := DefaultCacheConfig()
+	if config.Store == nil {
+		config.Store = defaults.Store
+	}
+	if config.TagHeader == "" {
+		config.TagHeader = defaults.TagHeader
+	}
+	return &ResponseCache{config: config}
+}
+
+// Cache returns a middleware that caches responses according to config.
+// It is shorthand for NewResponseCache(config).Middleware.
+//
+// Parameters:
+//   - config: The CacheConfig struct containing the cache configuration
+//
+// Returns:
+//   - A MiddleWare function that can be applied to HTTP handlers
+func Cache(config CacheConfig) MiddleWare {
+	return NewResponseCache(config).Middleware
+}
+
+// Key returns the cache key for r. It combines the method, the path, the
+// query string with its parameters sorted, and the configured Vary headers.
+func (c *ResponseCache) Key(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteByte(' ')
+	b.WriteString(r.URL.Path)
+	if query := r.URL.Query(); len(query) > 0 {
+		// url.Values.Encode sorts by key.
+		for _, values := range query {
+			slices.Sort(values)
+		}
+		b.WriteByte('?')
+		b.WriteString(query.Encode())
+	}
+	for _, name := range c.config.VaryHeaders {
+		b.WriteByte('\n')
+		b.WriteString(http.CanonicalHeaderKey(name))
+		b.WriteString(": ")
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// Purge removes the response cached under key.
+func (c *ResponseCache) Purge(key string) {
+	c.config.Store.Delete(key)
+}
+
+// PurgeTag removes every cached response that was stored with tag.
+func (c *ResponseCache) PurgeTag(tag string) {
+	c.config.Store.DeleteTag(tag)
+}
+
+// Middleware serves cached responses when they are fresh and stores new
+// responses that Cache-Control allows a shared cache to keep.
+//
+// Responses are stored when they carry max-age or s-maxage (which takes
+// precedence) and are not marked no-store, no-cache or private. Stale
+// responses are served while being refreshed in the background within the
+// stale-while-revalidate window, and in place of 5xx responses within the
+// stale-if-error window. Responses that were just stored are sent with
+// "X-Cache: MISS"; responses that cannot be cached get no X-Cache header.
+func (c *ResponseCache) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next(w, r)
+			return
+		}
+		reqDirectives := parseCacheControl(r.Header.Get("Cache-Control"))
+		if _, ok := reqDirectives["no-store"]; ok {
+			next(w, r)
+			return
+		}
+
+		key := c.Key(r)
+		var stale *CacheEntry
+		if _, noCache := reqDirectives["no-cache"]; !noCache {
+			if entry, ok := c.config.Store.Get(key); ok {
+				now := time.Now()
+				switch {
+				case now.Before(entry.FreshUntil):
+					writeCacheEntry(w, entry, "HIT")
+					return
+				case now.Before(entry.FreshUntil.Add(entry.StaleWhileRevalidate)):
+					c.revalidate(key, next, r)
+					writeCacheEntry(w, entry, "STALE")
+					return
+				case now.Before(entry.FreshUntil.Add(entry.StaleIfError)):
+					stale = entry
+				}
+			}
+		}
+
+		buf := newResponseBuffer()
+		next(buf, r)
+
+		if stale != nil && buf.status() >= http.StatusInternalServerError {
+			writeCacheEntry(w, stale, "STALE")
+			return
+		}
+		if c.store(key, r, buf) {
+			w.Header().Set("X-Cache", "MISS")
+		}
+		buf.writeTo(w)
+	}
+}
+
+// revalidate refreshes the entry for key in the background, at most once at
+// a time per key.
+func (c *ResponseCache) revalidate(key string, next http.HandlerFunc, r *http.Request) {
+	if _, running := c.revalidating.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	req := r.Clone(context.WithoutCancel(r.Context()))
+	go func() {
+		defer c.revalidating.Delete(key)
+		defer func() {
+			// A failed refresh keeps serving the stale entry.
+			recover()
+		}()
+		buf := newResponseBuffer()
+		next(buf, req)
+		if buf.status() < http.StatusInternalServerError {
+			c.store(key, req, buf)
+		}
+	}()
+}
+
+// store saves the buffered response under key if its headers allow it and
+// reports whether it did.
+func (c *ResponseCache) store(key string, r *http.Request, buf *responseBuffer) bool {
+	if !cacheableStatus(buf.status()) || buf.Header().Get("Set-Cookie") != "" {
+		return false
+	}
+	directives := parseCacheControl(buf.Header().Get("Cache-Control"))
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[d]; ok {
+			return false
+		}
+	}
+	if !c.keyCoversVary(buf.Header()) {
+		return false
+	}
+
+	ttl, ok := directiveSeconds(directives, "s-maxage")
+	if !ok {
+		if r.Header.Get("Authorization") != "" {
+			if _, public := directives["public"]; !public {
+				return false
+			}
+		}
+		if ttl, ok = directiveSeconds(directives, "max-age"); !ok {
+			return false
+		}
+	}
+	if ttl <= 0 {
+		return false
+	}
+	swr, _ := directiveSeconds(directives, "stale-while-revalidate")
+	sie, _ := directiveSeconds(directives, "stale-if-error")
+
+	var tags []string
+	for _, tag := range strings.Split(buf.Header().Get(c.config.TagHeader), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	now := time.Now()
+	c.config.Store.Set(key, &CacheEntry{
+		Status:               buf.status(),
+		Header:               buf.Header().Clone(),
+		Body:                 slices.Clone(buf.body.Bytes()),
+		Tags:                 tags,
+		StoredAt:             now,
+		FreshUntil:           now.Add(ttl),
+		StaleWhileRevalidate: swr,
+		StaleIfError:         sie,
+	})
+	return true
+}
+
+// keyCoversVary reports whether every request header named in the Vary
+// header of a response is part of the cache key, so the response is only
+// served to requests it was made for.
+func (c *ResponseCache) keyCoversVary(header http.Header) bool {
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if name == "*" || !slices.ContainsFunc(c.config.VaryHeaders, func(h string) bool {
+				return strings.EqualFold(h, name)
+			}) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// writeCacheEntry sends a stored response with Age and X-Cache headers.
+func writeCacheEntry(w http.ResponseWriter, entry *CacheEntry, status string) {
+	dst := w.Header()
+	for key, values := range entry.Header {
+		dst[key] = slices.Clone(values)
+	}
+	age := int(time.Since(entry.StoredAt) / time.Second)
+	dst.Set("Age", strconv.Itoa(age))
+	dst.Set("X-Cache", status)
+	w.WriteHeader(entry.Status)
+	w.Write(entry.Body)
+}
+
+// parseCacheControl parses a Cache-Control header into lower-cased directive
+// names and their (unquoted) values.
+func parseCacheControl(header string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+	}
+	return directives
+}
+
+// directiveSeconds returns the duration given by a delta-seconds directive.
+func directiveSeconds(directives map[string]string, name string) (time.Duration, bool) {
+	value, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// cacheableStatus reports whether responses with code may be stored.
+func cacheableStatus(code int) bool {
+	switch code {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package intake
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResponseCache(t *testing.T) {
+	var calls atomic.Int32
+	cache := NewResponseCache(CacheConfig{VaryHeaders: []string{"Accept-Language"}})
+
+	app := New()
+	app.AddEndpoint(http.MethodGet, "/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Header().Set("Cache-Tag", "item-"+r.PathValue("id"))
+		fmt.Fprintf(w, "call %d", n)
+	}, cache.Middleware)
+	app.AddEndpoint(http.MethodGet, "/private", func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "private, max-age=60")
+	}, cache.Middleware)
+
+	get := func(target, lang string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if lang != "" {
+			req.Header.Set("Accept-Language", lang)
+		}
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := get("/items/1?b=2&a=1", "en")
+	if got := first.Header().Get("X-Cache"); got != "MISS" {
+		t.Fatalf("expected MISS, got %q", got)
+	}
+
+	second := get("/items/1?a=1&b=2", "en")
+	if got := second.Header().Get("X-Cache"); got != "HIT" {
+		t.Fatalf("expected HIT for reordered query, got %q", got)
+	}
+	if second.Body.String() != first.Body.String() {
+		t.Fatalf("expected cached body %q, got %q", first.Body.String(), second.Body.String())
+	}
+
+	if got := get("/items/1?a=1&b=2", "de").Header().Get("X-Cache"); got != "MISS" {
+		t.Fatalf("expected MISS for different Vary header, got %q", got)
+	}
+
+	cache.PurgeTag("item-1")
+	if got := get("/items/1?a=1&b=2", "en").Header().Get("X-Cache"); got != "MISS" {
+		t.Fatalf("expected MISS after purge, got %q", got)
+	}
+
+	before := calls.Load()
+	get("/private", "")
+	get("/private", "")
+	if got := calls.Load() - before; got != 2 {
+		t.Fatalf("expected private responses not to be cached, handler ran %d times", got)
+	}
+	if got := get("/private", "").Header().Get("X-Cache"); got != "" {
+		t.Fatalf("expected no X-Cache header on uncacheable response, got %q", got)
+	}
+}
+
+func TestResponseCacheUnkeyedVary(t *testing.T) {
+	body := strings.Repeat("compressible ", 200)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Write([]byte(body))
+	}
+	get := func(h http.HandlerFunc, encoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/page", nil)
+		if encoding != "" {
+			req.Header.Set("Accept-Encoding", encoding)
+		}
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	// Compress adds "Vary: Accept-Encoding", which is not part of the key.
+	cached := Cache(CacheConfig{})(Compress(DefaultCompressConfig())(handler))
+	get(cached, "gzip")
+	rr := get(cached, "")
+	if got := rr.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("expected uncompressed response, got Content-Encoding %q", got)
+	}
+	if rr.Body.String() != body {
+		t.Fatalf("expected plain body")
+	}
+	if got := rr.Header().Get("X-Cache"); got != "" {
+		t.Fatalf("expected response not to be cached, got X-Cache %q", got)
+	}
+
+	// Once Accept-Encoding is keyed, each encoding is cached separately.
+	keyed := Cache(CacheConfig{VaryHeaders: []string{"accept-encoding"}})(Compress(DefaultCompressConfig())(handler))
+	get(keyed, "gzip")
+	if got := get(keyed, "gzip").Header().Get("X-Cache"); got != "HIT" {
+		t.Fatalf("expected HIT, got %q", got)
+	}
+	if got := get(keyed, "").Header().Get("X-Cache"); got != "MISS" {
+		t.Fatalf("expected MISS for other encoding, got %q", got)
+	}
+}
+
+func TestResponseCacheStale(t *testing.T) {
+	store := NewMemoryCacheStore(1 << 20)
+	cache := NewResponseCache(CacheConfig{Store: store})
+
+	var fail atomic.Bool
+	refreshed := make(chan struct{}, 1)
+	handler := cache.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60, stale-while-revalidate=60, stale-if-error=600")
+		w.Write([]byte("fresh"))
+		select {
+		case refreshed <- struct{}{}:
+		default:
+		}
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/report", nil)
+	handler(httptest.NewRecorder(), req)
+	<-refreshed
+	key := cache.Key(req)
+
+	expire := func(by time.Duration) {
+		entry, ok := store.Get(key)
+		if !ok {
+			t.Fatalf("expected entry to be stored")
+		}
+		stale := *entry
+		stale.FreshUntil = time.Now().Add(-by)
+		store.Set(key, &stale)
+	}
+
+	t.Run("stale-while-revalidate", func(t *testing.T) {
+		expire(time.Second)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		if got := rr.Header().Get("X-Cache"); got != "STALE" {
+			t.Fatalf("expected STALE, got %q", got)
+		}
+		select {
+		case <-refreshed:
+		case <-time.After(time.Second):
+			t.Fatalf("expected background revalidation")
+		}
+	})
+
+	t.Run("stale-if-error", func(t *testing.T) {
+		// Wait for the background refresh to finish storing its result.
+		for {
+			if _, running := cache.revalidating.Load(key); !running {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		expire(5 * time.Minute)
+		fail.Store(true)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		if rr.Code != http.StatusOK || rr.Body.String() != "fresh" {
+			t.Fatalf("expected stale response instead of error, got %d %q", rr.Code, rr.Body.String())
+		}
+	})
+}
+
+func TestMemoryCacheStoreEviction(t *testing.T) {
+	store := NewMemoryCacheStore(10)
+	entry := func(body string) *CacheEntry {
+		return &CacheEntry{Body: []byte(body), FreshUntil: time.Now().Add(time.Minute)}
+	}
+
+	store.Set("a", entry("aaaa"))
+	store.Set("b", entry("bbbb"))
+	store.Get("a")
+	store.Set("c", entry("cccc"))
+
+	if _, ok := store.Get("b"); ok {
+		t.Fatalf("expected least recently used entry to be evicted")
+	}
+	if _, ok := store.Get("a"); !ok {
+		t.Fatalf("expected recently used entry to be kept")
+	}
+	if got := store.Len(); got != 2 {
+		t.Fatalf("expected 2 entries, got %d", got)
+	}
+}
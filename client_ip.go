@@ -0,0 +1,220 @@
+// Package intake provides HTTP routing utilities.
+// This file contains middleware that resolves the real client address of
+// requests arriving through trusted reverse proxies.
+package intake
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// prefixSet is a list of IP prefixes.
+type prefixSet []netip.Prefix
+
+// parsePrefixes parses CIDRs and bare IP addresses. A bare address is
+// treated as a prefix covering only that address.
+func parsePrefixes(values []string) (prefixSet, error) {
+	set := make(prefixSet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, err
+			}
+			set = append(set, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address or CIDR %q", value)
+		}
+		set = append(set, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return set, nil
+}
+
+// contains reports whether addr is inside any prefix of the set.
+func (s prefixSet) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range s {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustedProxyConfig defines the configuration options for the TrustedProxies middleware.
+type TrustedProxyConfig struct {
+	// Proxies lists the addresses of trusted reverse proxies as CIDRs or
+	// single IPs, e.g. "10.0.0.0/8". Forwarding headers are only believed
+	// when the request comes from one of them.
+	Proxies []string
+
+	// RewriteHost replaces r.Host and r.URL.Host with the host the client
+	// asked for, taken from Forwarded or X-Forwarded-Host.
+	RewriteHost bool
+
+	// RewriteScheme sets r.URL.Scheme to the scheme the client used, taken
+	// from Forwarded or X-Forwarded-Proto.
+	RewriteScheme bool
+}
+
+type clientIPKey struct{}
+
+// ClientIP returns the address of the client that made r. Behind the
+// TrustedProxies middleware this is the address resolved from forwarding
+// headers; otherwise it is the address of the connection's peer. The result
+// is invalid if RemoteAddr cannot be parsed.
+func ClientIP(r *http.Request) netip.Addr {
+	if addr, ok := r.Context().Value(clientIPKey{}).(netip.Addr); ok {
+		return addr
+	}
+	return remoteAddr(r)
+}
+
+// remoteAddr parses the IP of r.RemoteAddr.
+func remoteAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, _ := netip.ParseAddr(host)
+	return addr.Unmap()
+}
+
+// TrustedProxies returns a middleware that resolves the client address of
+// requests forwarded by trusted proxies, making it available through
+// ClientIP.
+//
+// The forwarding chain is read from the Forwarded header (RFC 7239),
+// X-Forwarded-For or X-Real-IP, in that order of preference, and walked from
+// the right: every address appended by a trusted proxy is skipped, and the
+// first untrusted address is the client. Addresses further left were
+// supplied by the client and are never believed. Requests that do not come
+// from a trusted proxy keep their peer address.
+//
+// It panics if a proxy address is invalid.
+//
+// Parameters:
+//   - config: The TrustedProxyConfig struct containing the trusted proxies
+//
+// Returns:
+//   - A MiddleWare function that can be applied to HTTP handlers
+func TrustedProxies(config TrustedProxyConfig) MiddleWare {
+	trusted, err := parsePrefixes(config.Proxies)
+	if err != nil {
+		panic("intake: TrustedProxies: " + err.Error())
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			client := remoteAddr(r)
+			if client.IsValid() && trusted.contains(client) {
+				hops := forwardedHops(r.Header)
+				hop := forwardedHop{addr: client}
+				for i := len(hops) - 1; i >= 0; i-- {
+					if !hops[i].addr.IsValid() {
+						// Obfuscated or malformed; nothing further left can be trusted.
+						break
+					}
+					hop = hops[i]
+					if !trusted.contains(hop.addr) {
+						break
+					}
+				}
+				client = hop.addr
+
+				if config.RewriteScheme && (hop.proto == "http" || hop.proto == "https") {
+					r.URL.Scheme = hop.proto
+				}
+				if config.RewriteHost && hop.host != "" {
+					r.Host = hop.host
+					r.URL.Host = hop.host
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), clientIPKey{}, client)
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// forwardedHop is one entry of a forwarding chain.
+type forwardedHop struct {
+	addr  netip.Addr
+	proto string
+	host  string
+}
+
+// forwardedHops returns the forwarding chain from the Forwarded header, or
+// from X-Forwarded-For or X-Real-IP if it is absent, ordered from the client
+// towards this server. X-Forwarded-Proto and X-Forwarded-Host are attributed
+// to every hop, as proxies set them for the request as a whole.
+func forwardedHops(h http.Header) []forwardedHop {
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		var hops []forwardedHop
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			var hop forwardedHop
+			for _, pair := range strings.Split(element, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				value = strings.Trim(value, `"`)
+				switch strings.ToLower(key) {
+				case "for":
+					hop.addr = parseForwardedAddr(value)
+				case "proto":
+					hop.proto = strings.ToLower(value)
+				case "host":
+					hop.host = value
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	}
+
+	var addrs []string
+	if values := h.Values("X-Forwarded-For"); len(values) > 0 {
+		addrs = strings.Split(strings.Join(values, ","), ",")
+	} else if value := h.Get("X-Real-IP"); value != "" {
+		addrs = []string{value}
+	}
+	proto := lastListValue(h.Get("X-Forwarded-Proto"))
+	host := lastListValue(h.Get("X-Forwarded-Host"))
+
+	hops := make([]forwardedHop, 0, len(addrs))
+	for _, value := range addrs {
+		hops = append(hops, forwardedHop{
+			addr:  parseForwardedAddr(strings.TrimSpace(value)),
+			proto: strings.ToLower(proto),
+			host:  host,
+		})
+	}
+	return hops
+}
+
+// parseForwardedAddr parses an address as found in forwarding headers:
+// "192.0.2.1", "192.0.2.1:8080", "2001:db8::1" or "[2001:db8::1]:8080".
+// Obfuscated identifiers such as "unknown" or "_hidden" yield an invalid Addr.
+func parseForwardedAddr(value string) netip.Addr {
+	if addr, err := netip.ParseAddr(strings.Trim(value, "[]")); err == nil {
+		return addr.Unmap()
+	}
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return addrPort.Addr().Unmap()
+	}
+	return netip.Addr{}
+}
+
+// lastListValue returns the last element of a comma separated header value.
+func lastListValue(value string) string {
+	if i := strings.LastIndex(value, ","); i >= 0 {
+		value = value[i+1:]
+	}
+	return strings.TrimSpace(value)
+}
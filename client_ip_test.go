@@ -0,0 +1,100 @@
+package intake
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	app := New()
+	app.AddGlobalMiddleware(TrustedProxies(TrustedProxyConfig{
+		Proxies:       []string{"10.0.0.0/8", "2001:db8:ffff::/48", "192.0.2.10"},
+		RewriteHost:   true,
+		RewriteScheme: true,
+	}))
+	app.AddEndpoint(http.MethodGet, "/ip", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(ClientIP(r).String() + " " + r.URL.Scheme + " " + r.Host))
+	})
+
+	cases := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:   "direct client",
+			remote: "203.0.113.5:4000",
+			headers: map[string]string{
+				"X-Forwarded-For": "1.1.1.1",
+			},
+			want: "203.0.113.5  example.com",
+		},
+		{
+			name:   "x-forwarded-for chain",
+			remote: "10.0.0.2:4000",
+			headers: map[string]string{
+				"X-Forwarded-For":   "6.6.6.6, 198.51.100.7, 10.1.2.3",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "shop.example.com",
+			},
+			want: "198.51.100.7 https shop.example.com",
+		},
+		{
+			name:   "all hops trusted",
+			remote: "10.0.0.2:4000",
+			headers: map[string]string{
+				"X-Forwarded-For": "10.9.9.9, 10.1.2.3",
+			},
+			want: "10.9.9.9  example.com",
+		},
+		{
+			name:   "x-real-ip",
+			remote: "192.0.2.10:4000",
+			headers: map[string]string{
+				"X-Real-IP": "198.51.100.8",
+			},
+			want: "198.51.100.8  example.com",
+		},
+		{
+			name:   "forwarded",
+			remote: "[2001:db8:ffff::1]:4000",
+			headers: map[string]string{
+				"Forwarded":       `for=6.6.6.6, for="[2001:db8::7]:1234";proto=https;host=api.example.com, for=10.0.0.9`,
+				"X-Forwarded-For": "9.9.9.9",
+			},
+			want: "2001:db8::7 https api.example.com",
+		},
+		{
+			name:   "obfuscated hop",
+			remote: "10.0.0.2:4000",
+			headers: map[string]string{
+				"Forwarded": "for=198.51.100.7, for=_hidden, for=10.0.0.3",
+			},
+			want: "10.0.0.3  example.com",
+		},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/ip", nil)
+		req.URL.Scheme = ""
+		req.RemoteAddr = tc.remote
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+		if got := rr.Body.String(); got != tc.want {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestClientIPWithoutMiddleware(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "[::ffff:203.0.113.5]:4000"
+	if got := ClientIP(req).String(); got != "203.0.113.5" {
+		t.Fatalf("expected peer address, got %q", got)
+	}
+}
@@ -0,0 +1,128 @@
+// Package intake provides HTTP routing utilities.
+// This file contains middleware that collapses concurrent identical requests
+// into a single handler execution.
+package intake
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// CoalesceConfig defines the configuration options for the Coalesce middleware.
+type CoalesceConfig struct {
+	// KeyFunc returns the key that identifies identical requests. Requests
+	// with an empty key are never coalesced. Default value uses the method,
+	// path and raw query of the request, and returns an empty key for
+	// requests carrying Authorization or Cookie headers, so one user's
+	// response is never sent to another. A custom KeyFunc for authenticated
+	// routes must include the caller's identity in the key.
+	KeyFunc func(r *http.Request) string
+}
+
+// coalesceCall is a single shared handler execution and its waiters.
+type coalesceCall struct {
+	done     chan struct{}
+	buf      *responseBuffer
+	panicked any
+	waiters  int
+	cancel   context.CancelFunc
+}
+
+// Coalesce returns a middleware that collapses concurrent identical GET and
+// HEAD requests into one handler execution and sends the recorded response
+// to every waiting client.
+//
+// The shared execution runs with a context that is only canceled once every
+// waiting client has gone away, so one client disconnecting does not fail
+// the request for the others. Each client still stops waiting as soon as its
+// own context is canceled. Responses are buffered in memory, so streaming
+// routes should not be coalesced.
+//
+// Parameters:
+//   - config: The CoalesceConfig struct containing the coalescing configuration
+//
+// Returns:
+//   - A MiddleWare function that can be applied to HTTP handlers
+func Coalesce(config CoalesceConfig) MiddleWare {
+	keyFunc := config.KeyFunc
+	if keyFunc == nil {
+		keyFunc = func(r *http.Request) string {
+			if r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "" {
+				return ""
+			}
+			return r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery
+		}
+	}
+
+	var mu sync.Mutex
+	calls := make(map[string]*coalesceCall)
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next(w, r)
+				return
+			}
+			key := keyFunc(r)
+			if key == "" {
+				next(w, r)
+				return
+			}
+
+			mu.Lock()
+			call, ok := calls[key]
+			if !ok {
+				ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+				call = &coalesceCall{
+					done:   make(chan struct{}),
+					buf:    newResponseBuffer(),
+					cancel: cancel,
+				}
+				calls[key] = call
+				req := r.WithContext(ctx)
+				go func() {
+					defer func() {
+						call.panicked = recover()
+						cancel()
+						mu.Lock()
+						// A canceled call may already have been replaced.
+						if calls[key] == call {
+							delete(calls, key)
+						}
+						mu.Unlock()
+						close(call.done)
+					}()
+					next(call.buf, req)
+				}()
+			}
+			call.waiters++
+			mu.Unlock()
+
+			select {
+			case <-call.done:
+			case <-r.Context().Done():
+				mu.Lock()
+				call.waiters--
+				if call.waiters == 0 {
+					// Later requests must not join the canceled call.
+					call.cancel()
+					if calls[key] == call {
+						delete(calls, key)
+					}
+				}
+				mu.Unlock()
+				return
+			}
+
+			mu.Lock()
+			call.waiters--
+			mu.Unlock()
+
+			if call.panicked != nil {
+				panic(call.panicked)
+			}
+			call.buf.writeTo(w)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package intake
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalesce(t *testing.T) {
+	const clients = 10
+	var executions, arrivals atomic.Int32
+	release := make(chan struct{})
+
+	app := New()
+	app.AddEndpoint(http.MethodGet, "/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		executions.Add(1)
+		<-release
+		w.Header().Set("X-Item", r.PathValue("id"))
+		w.Write([]byte("item " + r.PathValue("id")))
+	}, Coalesce(CoalesceConfig{
+		KeyFunc: func(r *http.Request) string {
+			arrivals.Add(1)
+			return r.URL.Path
+		},
+	}))
+
+	var wg sync.WaitGroup
+	recorders := make([]*httptest.ResponseRecorder, clients)
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.Mux.ServeHTTP(recorders[i], httptest.NewRequest(http.MethodGet, "/items/7", nil))
+		}()
+	}
+	for arrivals.Load() < clients {
+		time.Sleep(time.Millisecond)
+	}
+	// Give the last arrivals time to join the in-flight call.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := executions.Load(); got != 1 {
+		t.Fatalf("expected 1 handler execution, got %d", got)
+	}
+	for i, rr := range recorders {
+		if rr.Body.String() != "item 7" || rr.Header().Get("X-Item") != "7" {
+			t.Fatalf("client %d: unexpected response %q", i, rr.Body.String())
+		}
+	}
+}
+
+func TestCoalesceWaiterCancellation(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var sharedCanceled atomic.Bool
+
+	handler := Coalesce(CoalesceConfig{})(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-release:
+			w.Write([]byte("done"))
+		case <-r.Context().Done():
+			sharedCanceled.Store(true)
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderDone := make(chan struct{})
+	go func() {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil).WithContext(ctx))
+		close(leaderDone)
+	}()
+	<-started
+
+	follower := httptest.NewRecorder()
+	followerDone := make(chan struct{})
+	go func() {
+		handler(follower, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		close(followerDone)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	cancel()
+	select {
+	case <-leaderDone:
+	case <-time.After(time.Second):
+		t.Fatalf("expected canceled client to stop waiting")
+	}
+
+	close(release)
+	<-followerDone
+	if sharedCanceled.Load() {
+		t.Fatalf("expected shared execution to survive one client canceling")
+	}
+	if got := follower.Body.String(); got != "done" {
+		t.Fatalf("expected follower to receive response, got %q", got)
+	}
+}
+
+func TestCoalesceSkipsCredentialedRequests(t *testing.T) {
+	var executions atomic.Int32
+	release := make(chan struct{})
+
+	app := New()
+	app.AddEndpoint(http.MethodGet, "/me", func(w http.ResponseWriter, r *http.Request) {
+		executions.Add(1)
+		<-release
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}, Coalesce(CoalesceConfig{}))
+
+	var wg sync.WaitGroup
+	tokens := []string{"Bearer alice", "Bearer bob"}
+	recorders := make([]*httptest.ResponseRecorder, len(tokens))
+	for i, token := range tokens {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			req.Header.Set("Authorization", token)
+			app.Mux.ServeHTTP(recorders[i], req)
+		}()
+	}
+	deadline := time.Now().Add(time.Second)
+	for executions.Load() < int32(len(tokens)) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if got := executions.Load(); got != int32(len(tokens)) {
+		t.Fatalf("expected %d handler executions, got %d", len(tokens), got)
+	}
+	for i, rr := range recorders {
+		if rr.Body.String() != tokens[i] {
+			t.Fatalf("client %d: expected its own response, got %q", i, rr.Body.String())
+		}
+	}
+}
+
+func TestCoalesceAfterCanceledCall(t *testing.T) {
+	var executions atomic.Int32
+	hold := make(chan struct{})
+	handler := Coalesce(CoalesceConfig{})(func(w http.ResponseWriter, r *http.Request) {
+		if executions.Add(1) == 1 {
+			// Keep the canceled call in flight while the next request arrives.
+			<-r.Context().Done()
+			<-hold
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if err := r.Context().Err(); err != nil {
+			t.Errorf("expected a live context, got %v", err)
+		}
+		w.Write([]byte("fresh"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/report", nil).WithContext(ctx))
+	}()
+	for executions.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	// Release the canceled call eventually, so a request that joined it
+	// fails instead of hanging.
+	timer := time.AfterFunc(time.Second, func() { close(hold) })
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/report", nil))
+	if timer.Stop() {
+		close(hold)
+	}
+	if got := executions.Load(); got != 2 {
+		t.Fatalf("expected the handler to run again, ran %d times", got)
+	}
+	if rr.Code != http.StatusOK || rr.Body.String() != "fresh" {
+		t.Fatalf("expected a fresh response, got %d %q", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,366 @@
+// Package intake provides HTTP routing utilities.
+// This file contains middleware for compressing responses based on the
+// client's Accept-Encoding header.
+package intake
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Encoder is a reusable streaming compressor. The writers from compress/gzip
+// and compress/flate satisfy it, as do most third-party brotli and zstd
+// implementations.
+type Encoder interface {
+	io.WriteCloser
+	// Flush writes any pending compressed data to the underlying writer.
+	Flush() error
+	// Reset discards the encoder's state and makes it write to w.
+	Reset(w io.Writer)
+}
+
+// ContentEncoding registers a Content-Encoding that the Compress middleware
+// can produce.
+type ContentEncoding struct {
+	// Name is the token used in Accept-Encoding and Content-Encoding, e.g. "gzip".
+	Name string
+	// NewEncoder creates an encoder writing to w. Encoders are pooled and
+	// reused with Reset, so NewEncoder is only called when the pool is empty.
+	NewEncoder func(w io.Writer) Encoder
+}
+
+// GzipEncoding returns a ContentEncoding for gzip at the given compression
+// level. Invalid levels fall back to gzip.DefaultCompression.
+func GzipEncoding(level int) ContentEncoding {
+	if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+		level = gzip.DefaultCompression
+	}
+	return ContentEncoding{
+		Name: "gzip",
+		NewEncoder: func(w io.Writer) Encoder {
+			enc, _ := gzip.NewWriterLevel(w, level)
+			return enc
+		},
+	}
+}
+
+// DeflateEncoding returns a ContentEncoding for deflate at the given
+// compression level. Invalid levels fall back to flate.DefaultCompression.
+func DeflateEncoding(level int) ContentEncoding {
+	if _, err := flate.NewWriter(io.Discard, level); err != nil {
+		level = flate.DefaultCompression
+	}
+	return ContentEncoding{
+		Name: "deflate",
+		NewEncoder: func(w io.Writer) Encoder {
+			enc, _ := flate.NewWriter(w, level)
+			return enc
+		},
+	}
+}
+
+// CompressConfig defines the configuration options for the Compress middleware.
+type CompressConfig struct {
+	// Encodings is the registry of available encodings in order of server
+	// preference. When the client weights several encodings equally, the one
+	// listed first wins. Default value is gzip followed by deflate.
+	Encodings []ContentEncoding
+
+	// MinLength is the minimum response size in bytes worth compressing.
+	// Smaller responses are sent as is unless the handler flushes first.
+	MinLength int
+
+	// SkipContentTypes lists media types, or prefixes ending in "/", that are
+	// already compressed and should be sent as is.
+	SkipContentTypes []string
+}
+
+// DefaultCompressConfig returns a compression configuration with common settings.
+// The default configuration:
+// - Offers gzip and deflate at their default levels
+// - Compresses responses of 1 KiB or more
+// - Skips images, audio, video and common archive formats
+func DefaultCompressConfig() CompressConfig {
+	return CompressConfig{
+		Encodings: []ContentEncoding{
+			GzipEncoding(gzip.DefaultCompression),
+			DeflateEncoding(flate.DefaultCompression),
+		},
+		MinLength: 1024,
+		SkipContentTypes: []string{
+			"image/",
+			"audio/",
+			"video/",
+			"font/woff",
+			"font/woff2",
+			"application/zip",
+			"application/gzip",
+			"application/x-gzip",
+			"application/zstd",
+			"application/x-7z-compressed",
+			"application/x-rar-compressed",
+			"application/octet-stream",
+		},
+	}
+}
+
+// encoderPool hands out reusable encoders for a single ContentEncoding.
+type encoderPool struct {
+	name string
+	pool sync.Pool
+}
+
+// Compress returns a middleware that compresses response bodies using the
+// best encoding the client accepts, honoring Accept-Encoding q-values.
+//
+// Compression starts once the body reaches MinLength bytes or the handler
+// flushes, so streaming responses such as server-sent events keep working
+// through http.Flusher. Responses that already carry a Content-Encoding, use
+// a skipped content type, or have no body are sent unchanged. When a response
+// is compressed, Content-Length is removed and a strong ETag is weakened.
+//
+// Parameters:
+//   - config: The CompressConfig struct containing the compression configuration
+//
+// Returns:
+//   - A MiddleWare function that can be applied to HTTP handlers
+func Compress(config CompressConfig) MiddleWare {
+	if len(config.Encodings) == 0 {
+		config.Encodings = DefaultCompressConfig().Encodings
+	}
+
+	pools := make([]*encoderPool, 0, len(config.Encodings))
+	for _, enc := range config.Encodings {
+		newEncoder := enc.NewEncoder
+		p := &encoderPool{name: strings.ToLower(enc.Name)}
+		p.pool.New = func() any {
+			return newEncoder(io.Discard)
+		}
+		pools = append(pools, p)
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			if r.Method == http.MethodHead {
+				next(w, r)
+				return
+			}
+			p := negotiateEncoding(r.Header.Get("Accept-Encoding"), pools)
+			if p == nil {
+				next(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, pool: p, config: &config}
+			defer cw.close()
+			next(cw, r)
+		}
+	}
+}
+
+// negotiateEncoding picks the pool with the highest q-value in the
+// Accept-Encoding header, or nil if the client accepts none of them.
+func negotiateEncoding(acceptEncoding string, pools []*encoderPool) *encoderPool {
+	if acceptEncoding == "" {
+		return nil
+	}
+
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		token, params, _ := strings.Cut(part, ";")
+		token = strings.ToLower(strings.TrimSpace(token))
+		if token == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(key, "q") {
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					q = v
+				}
+			}
+		}
+		weights[token] = q
+	}
+
+	var best *encoderPool
+	bestQ := 0.0
+	for _, p := range pools {
+		q, ok := weights[p.name]
+		if !ok {
+			q = weights["*"]
+		}
+		if q > bestQ {
+			best, bestQ = p, q
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the start of a response until it can decide whether
+// compressing it is worthwhile, then streams through the chosen encoder.
+type compressWriter struct {
+	http.ResponseWriter
+	pool   *encoderPool
+	config *CompressConfig
+
+	code        int
+	wroteHeader bool
+	decided     bool
+	encoder     Encoder
+	buf         []byte
+}
+
+// WriteHeader records the status code; it is sent once the encoding is decided.
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader || cw.decided {
+		return
+	}
+	if code >= 100 && code <= 199 {
+		// Informational responses are sent immediately and do not end the header.
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.code = code
+	cw.wroteHeader = true
+	if !bodyAllowed(code) {
+		cw.decide(false)
+	}
+}
+
+// Write buffers data until MinLength is reached, then compresses it.
+func (cw *compressWriter) Write(data []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, data...)
+		if len(cw.buf) < cw.config.MinLength {
+			return len(data), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(data)
+	}
+	return cw.ResponseWriter.Write(data)
+}
+
+// Flush sends any buffered data, compressing it if the response qualifies,
+// and flushes the underlying writer.
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if cw.encoder != nil {
+		cw.encoder.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// decide sends the header and any buffered data, compressing from here on
+// when the response qualifies. large reports whether the body is big enough
+// (or being streamed) to be worth compressing.
+func (cw *compressWriter) decide(large bool) error {
+	cw.decided = true
+	h := cw.ResponseWriter.Header()
+
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		// Sniff before compressing, or net/http would sniff the compressed bytes.
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	if large && cw.shouldCompress(h) {
+		h.Set("Content-Encoding", cw.pool.name)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.encoder = cw.pool.pool.Get().(Encoder)
+		cw.encoder.Reset(cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.code)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+// shouldCompress reports whether the response headers allow compression.
+func (cw *compressWriter) shouldCompress(h http.Header) bool {
+	if !bodyAllowed(cw.code) || cw.code == http.StatusPartialContent {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	contentType := strings.ToLower(h.Get("Content-Type"))
+	if mediaType, _, ok := strings.Cut(contentType, ";"); ok {
+		contentType = mediaType
+	}
+	contentType = strings.TrimSpace(contentType)
+	for _, skip := range cw.config.SkipContentTypes {
+		skip = strings.ToLower(skip)
+		if contentType == skip || (strings.HasSuffix(skip, "/") && strings.HasPrefix(contentType, skip)) {
+			return false
+		}
+	}
+	return true
+}
+
+// close finishes the response once the handler has returned.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if !cw.wroteHeader {
+			// The handler wrote nothing; let net/http send its default response.
+			return
+		}
+		cw.decide(len(cw.buf) > 0 && len(cw.buf) >= cw.config.MinLength)
+	}
+	if cw.encoder != nil {
+		cw.encoder.Close()
+		cw.encoder.Reset(io.Discard)
+		cw.pool.pool.Put(cw.encoder)
+		cw.encoder = nil
+	}
+}
+
+// bodyAllowed reports whether a response with the given status may have a body.
+func bodyAllowed(code int) bool {
+	switch {
+	case code >= 100 && code <= 199:
+		return false
+	case code == http.StatusNoContent, code == http.StatusNotModified:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package intake
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	body := strings.Repeat("intake compresses responses. ", 100)
+
+	app := New()
+	app.AddGlobalMiddleware(Compress(DefaultCompressConfig()))
+	app.AddEndpoint(http.MethodGet, "/text", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", "2900")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(body))
+	})
+	app.AddEndpoint(http.MethodGet, "/small", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tiny"))
+	})
+	app.AddEndpoint(http.MethodGet, "/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(body))
+	})
+
+	t.Run("gzip above threshold", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/text", nil)
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Fatalf("expected Content-Encoding gzip, got %q", got)
+		}
+		if got := rr.Header().Get("Content-Length"); got != "" {
+			t.Fatalf("expected Content-Length to be removed, got %q", got)
+		}
+		if got := rr.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Fatalf("expected Vary Accept-Encoding, got %q", got)
+		}
+		if got := rr.Header().Get("ETag"); got != `W/"v1"` {
+			t.Fatalf("expected weakened ETag, got %q", got)
+		}
+		zr, err := gzip.NewReader(rr.Body)
+		if err != nil {
+			t.Fatalf("failed to create gzip reader: %v", err)
+		}
+		decoded, _ := io.ReadAll(zr)
+		if string(decoded) != body {
+			t.Fatalf("decoded body does not match original")
+		}
+	})
+
+	t.Run("q-values select deflate", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/text", nil)
+		req.Header.Set("Accept-Encoding", "gzip;q=0, deflate;q=0.5")
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("Content-Encoding"); got != "deflate" {
+			t.Fatalf("expected Content-Encoding deflate, got %q", got)
+		}
+		decoded, _ := io.ReadAll(flate.NewReader(rr.Body))
+		if string(decoded) != body {
+			t.Fatalf("decoded body does not match original")
+		}
+	})
+
+	t.Run("skips small and precompressed responses", func(t *testing.T) {
+		for _, path := range []string{"/small", "/image"} {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rr := httptest.NewRecorder()
+			app.Mux.ServeHTTP(rr, req)
+
+			if got := rr.Header().Get("Content-Encoding"); got != "" {
+				t.Fatalf("%s: expected no Content-Encoding, got %q", path, got)
+			}
+		}
+	})
+
+	t.Run("no acceptable encoding", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/text", nil)
+		req.Header.Set("Accept-Encoding", "br")
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("Content-Encoding"); got != "" {
+			t.Fatalf("expected no Content-Encoding, got %q", got)
+		}
+		if rr.Body.String() != body {
+			t.Fatalf("expected uncompressed body")
+		}
+	})
+}
+
+func TestCompressFlush(t *testing.T) {
+	events := make(chan string)
+	app := New()
+	app.AddGlobalMiddleware(Compress(DefaultCompressConfig()))
+	app.AddEndpoint(http.MethodGet, "/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for event := range events {
+			io.WriteString(w, "data: "+event+"\n\n")
+			w.(http.Flusher).Flush()
+		}
+	})
+
+	server := httptest.NewServer(app.Mux)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	go func() {
+		events <- "one"
+	}()
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("expected Content-Encoding gzip, got %q", got)
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to create gzip reader: %v", err)
+	}
+	line, err := bufio.NewReader(zr).ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read flushed event: %v", err)
+	}
+	if line != "data: one\n" {
+		t.Fatalf("expected first event, got %q", line)
+	}
+	close(events)
+}
@@ -3,18 +3,16 @@
 package intake
 
 import (
+	"log/slog"
 	"net/http"
-	"net/url"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-type originPattern struct {
-	scheme string
-	suffix string
-}
-
 type corsPolicy struct {
 	allowedMethods     []string
 	allowedMethodsSet  map[string]struct{}
@@ -22,14 +20,23 @@ type corsPolicy struct {
 	allowedHeaders     []string
 	allowedHeadersSet  map[string]struct{}
 	allowedHeadersHeader string
-	allowedOrigins     map[string]struct{}
+	allowedOrigins     map[string]string
 	allowedPatterns    []originPattern
+	allowedRegexps     []originRegexp
+	allowNullOrigin    bool
+	allowPrivateNetwork bool
+	privateNetworkID   string
+	privateNetworkName string
 	allowAnyOrigin     bool
 	allowAnyHeader     bool
 	exposeHeaders      []string
 	exposeHeadersHeader string
 	allowCredentials   bool
 	maxAge             int
+	allowOriginFunc    func(r *http.Request, origin string) (bool, error)
+	originCache        *originCache
+	onDecision         func(r *http.Request, d CORSDecision)
+	debug              bool
 }
 
 // CORSConfig defines the configuration options for the CORS middleware.
@@ -41,6 +48,18 @@ type CORSConfig struct {
 	// If the special "*" value is present in the list, all origins will be allowed.
 	// Default value is ["*"], which allows any origin.
 	// Examples: ["https://example.com", "https://*.example.com", "*"]
+	//
+	// Hosts are compared case-insensitively, default ports are ignored and
+	// Unicode host names are matched by their punycode form. Besides exact
+	// origins, entries may be:
+	//   - "https://*.example.com": any subdomain of example.com, at any depth
+	//   - "http://localhost:*": any port, including the default one
+	//   - "^https://pr-[0-9]+\.preview\.example\.com$": a regular expression,
+	//     matched in full against the normalized origin
+	//   - "null": the opaque origin of sandboxed documents and local files,
+	//     which "*" does not cover
+	//
+	// Malformed entries make the configuration invalid.
 	AllowedOrigins []string
 
 	// AllowedMethods is a list of HTTP methods the client is allowed to use with
@@ -70,6 +89,45 @@ type CORSConfig struct {
 	// can be cached by the browser. Default is 86400 seconds (24 hours).
 	// This controls the Access-Control-Max-Age header.
 	MaxAge int
+
+	// AllowOriginFunc is consulted for origins not matched by AllowedOrigins,
+	// e.g. to look them up in a tenant database. An origin is allowed if it
+	// returns true; an error is treated as a rejection.
+	AllowOriginFunc func(r *http.Request, origin string) (bool, error)
+
+	// OriginCacheTTL caches the results of AllowOriginFunc per origin for the
+	// given duration. Only enable it if the result depends on the origin
+	// alone, not on the rest of the request. Zero disables caching.
+	OriginCacheTTL time.Duration
+
+	// AllowPrivateNetwork answers Private Network Access preflights, which
+	// Chrome sends with Access-Control-Request-Private-Network before a
+	// public website calls a private or local address, with
+	// Access-Control-Allow-Private-Network. If false, they are rejected.
+	AllowPrivateNetwork bool
+
+	// PrivateNetworkID and PrivateNetworkName identify this device in the
+	// browser's Private Network Access permission prompt. The ID is six
+	// colon-separated hex bytes like "01:23:45:67:89:AB"; the name is up to
+	// 248 characters of [a-z0-9_.-]. Both are only sent with
+	// AllowPrivateNetwork.
+	PrivateNetworkID   string
+	PrivateNetworkName string
+
+	// OnDecision, if set, is called with the outcome of every CORS request,
+	// allowed or not, e.g. to log why a browser reported a CORS error. See
+	// LogCORSDecisions.
+	OnDecision func(r *http.Request, d CORSDecision)
+
+	// Logger receives every decision when OnDecision is not set: rejected
+	// requests at Warn level and allowed ones at Debug level. Default is
+	// slog.Default().
+	Logger *slog.Logger
+
+	// Debug adds an X-CORS-Debug header explaining each decision to the
+	// response. It reveals the policy to any caller, so only enable it
+	// outside production.
+	Debug bool
 }
 
 // DefaultCORSConfig returns a default CORS configuration with common settings.
@@ -114,103 +172,239 @@ func DefaultCORSConfig() CORSConfig {
 // - It supports wildcard origins, domain pattern matching, and specific origin lists
 // - It ensures compliance with security requirements (e.g., disallowing credentials with wildcard origins)
 //
+// CORS panics if config is invalid, e.g. has a malformed origin such as
+// "https://example.com/". Use NewCORSHandler or NewCORS to get the error
+// instead.
+//
 // Parameters:
 //   - config: The CORSConfig struct containing CORS policy configuration
 //
 // Returns:
 //   - A MiddleWare function that can be applied to HTTP handlers
 func CORS(config CORSConfig) MiddleWare {
+	h, err := NewCORSHandler(config)
+	if err != nil {
+		panic("intake: CORS: " + err.Error())
+	}
+	return h.Middleware
+}
+
+// CORSHandler applies a CORS policy that can be replaced at runtime. The
+// policy is swapped atomically, so requests in flight always see either the
+// old or the new policy in full.
+type CORSHandler struct {
+	policy atomic.Pointer[corsPolicy]
+	stats  corsCounters
+}
+
+// NewCORSHandler creates a CORSHandler applying config. Use its Middleware
+// method where CORS would be used, and Update to change the policy later.
+//
+// Parameters:
+//   - config: The CORSConfig struct containing CORS policy configuration
+//
+// Returns:
+//   - A CORSHandler applying config, or an error if config is invalid
+func NewCORSHandler(config CORSConfig) (*CORSHandler, error) {
+	h := &CORSHandler{}
+	if err := h.Update(config); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Update replaces the policy with one built from config. Results cached for
+// AllowOriginFunc are discarded. If config is invalid, the current policy is
+// kept and an error is returned.
+//
+// Parameters:
+//   - config: The CORSConfig struct containing the new CORS policy configuration
+func (h *CORSHandler) Update(config CORSConfig) error {
 	// Validate the configuration
 	// Ensure we have at least one allowed method if not explicitly set
 	if len(config.AllowedMethods) == 0 {
 		config.AllowedMethods = []string{http.MethodGet, http.MethodPost, http.MethodHead}
 	}
 
-	policy := buildPolicy(config)
+	policy, err := buildPolicy(config)
+	if err != nil {
+		return err
+	}
+	h.policy.Store(&policy)
+	return nil
+}
 
-	return func(next http.HandlerFunc) http.HandlerFunc {
-		return func(w http.ResponseWriter, r *http.Request) {
-			origin := r.Header.Get("Origin")
-			if origin == "" {
-				// Not a CORS request or same origin request - proceed without CORS headers
-				next(w, r)
-				return
-			}
+// Middleware applies the current policy to requests handled by next. Routes
+// with their own policy, set with WithCORS, are passed through untouched.
+func (h *CORSHandler) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if opts := routeOptionsFrom(r); opts != nil && opts.cors != nil && opts.cors != h {
+			next(w, r)
+			return
+		}
 
-			// Check if the origin is allowed by the configured policy
-			originAllowed := policy.isOriginAllowed(origin)
-			if !originAllowed {
-				// Origin not allowed, pass through without CORS headers
-				// This maintains security by not acknowledging invalid cross-origin requests
-				next(w, r)
-				return
+		policy := *h.policy.Load()
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// Not a CORS request or same origin request - proceed without CORS headers
+			next(w, r)
+			return
+		}
+
+		// Check if the origin is allowed by the configured policy
+		preflight := r.Method == http.MethodOptions
+		d := CORSDecision{
+			Origin:         origin,
+			Method:         r.Method,
+			Preflight:      preflight,
+			RequestHeaders: r.Header.Get("Access-Control-Request-Headers"),
+		}
+		if preflight {
+			d.Method = r.Header.Get("Access-Control-Request-Method")
+		}
+		d.MatchedRule, d.Err = policy.matchOrigin(r, origin)
+		if d.MatchedRule == "" {
+			// Origin not allowed, pass through without CORS headers
+			// This maintains security by not acknowledging invalid cross-origin requests
+			d.Rejection = CORSRejectedOrigin
+			h.decide(w, r, policy, d)
+			next(w, r)
+			return
+		}
+
+		// Handle preflight OPTIONS requests
+		// Preflight requests are sent by browsers before the actual request to check
+		// if the CORS request is allowed by the server
+		if preflight {
+			// Set standard CORS headers for all responses
+			corsHeaders(w, policy, origin)
+
+			// Set cache duration for preflight response
+			// This helps reduce the number of preflight requests
+			if policy.maxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(policy.maxAge))
 			}
 
-			// Handle preflight OPTIONS requests
-			// Preflight requests are sent by browsers before the actual request to check
-			// if the CORS request is allowed by the server
-			if r.Method == http.MethodOptions {
-				// Set standard CORS headers for all responses
-				corsHeaders(w, policy, origin)
-
-				// Set cache duration for preflight response
-				// This helps reduce the number of preflight requests
-				if policy.maxAge > 0 {
-					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(policy.maxAge))
-				}
-
-				// Check if the requested HTTP method is allowed
-				requestMethod := r.Header.Get("Access-Control-Request-Method")
-				if requestMethod != "" {
-					_, methodAllowed := policy.allowedMethodsSet[requestMethod]
-					if !methodAllowed {
-						// Method not allowed - respond with 403 Forbidden
-						w.WriteHeader(http.StatusForbidden)
-						return
-					}
-				}
-
-				// Set the list of allowed HTTP methods
-				if policy.allowedMethodsHeader != "" {
-					w.Header().Set("Access-Control-Allow-Methods", policy.allowedMethodsHeader)
-				}
-
-				// Handle the requested headers check
-				requestHeaders := r.Header.Get("Access-Control-Request-Headers")
-				if len(policy.allowedHeaders) > 0 || policy.allowAnyHeader {
-					if policy.allowAnyHeader {
-						// If wildcard is configured for headers, mirror the requested headers
-						// This allows the browser to send any headers it needs
-						if requestHeaders != "" {
-							w.Header().Set("Access-Control-Allow-Headers", requestHeaders)
-						}
-					} else {
-						// Otherwise, only allow the specifically configured headers,
-						// and reject preflights that ask for disallowed headers.
-						if requestHeaders != "" && !policy.areHeadersAllowed(requestHeaders) {
-							w.WriteHeader(http.StatusForbidden)
-							return
-						}
-						if policy.allowedHeadersHeader != "" {
-							w.Header().Set("Access-Control-Allow-Headers", policy.allowedHeadersHeader)
-						}
-					}
-				} else if requestHeaders != "" {
-					// No allowed headers configured: reject explicit header requests.
-					w.WriteHeader(http.StatusForbidden)
-					return
-				}
-
-				// Preflight requests only need headers, not content
-				// Respond with 204 No Content status and return immediately
-				w.WriteHeader(http.StatusNoContent)
+			// Reject preflights asking for a method, headers or network
+			// access the policy does not allow with 403 Forbidden
+			if d.Rejection = policy.checkPreflight(r); d.Rejection != "" {
+				h.decide(w, r, policy, d)
+				w.WriteHeader(http.StatusForbidden)
 				return
 			}
+			preflightHeaders(w, r, policy)
+
+			// Preflight requests only need headers, not content
+			// Respond with 204 No Content status and return immediately
+			d.Allowed = true
+			h.decide(w, r, policy, d)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
 
-			// Handle actual CORS request (not a preflight)
-			// Apply the CORS headers and continue with request processing
-			corsHeaders(w, policy, origin)
-			next(w, r)
+		// Handle actual CORS request (not a preflight)
+		// Apply the CORS headers and continue with request processing
+		corsHeaders(w, policy, origin)
+		d.Allowed = true
+		h.decide(w, r, policy, d)
+		next(w, r)
+	}
+}
+
+// WithCORS gives a route its own CORS policy, replacing any global CORS
+// middleware for it. Preflights for the route are answered with this policy
+// too, as long as the path has an OPTIONS endpoint, e.g. from
+// AddOptionsEndpoints. Applied with Endpoints.With, all the endpoints share
+// one policy. It panics if config is invalid, e.g. has a malformed origin.
+//
+// Parameters:
+//   - config: The CORSConfig struct containing the route's CORS policy
+func WithCORS(config CORSConfig) EndpointOption {
+	h, err := NewCORSHandler(config)
+	if err != nil {
+		panic("intake: WithCORS: " + err.Error())
+	}
+	return WithCORSHandler(h)
+}
+
+// WithCORSHandler is like WithCORS but uses h, whose policy can be changed
+// at runtime with h.Update.
+//
+// Parameters:
+//   - h: The CORSHandler applying the route's CORS policy
+func WithCORSHandler(h *CORSHandler) EndpointOption {
+	return func(o *routeOptions) {
+		o.cors = h
+	}
+}
+
+// CORSRejection names the check a CORS request failed.
+type CORSRejection string
+
+const (
+	// CORSRejectedOrigin means the origin is not allowed. The request is
+	// passed on without CORS headers, so the browser blocks the response.
+	CORSRejectedOrigin CORSRejection = "origin"
+	// CORSRejectedMethod means Access-Control-Request-Method is not allowed.
+	CORSRejectedMethod CORSRejection = "method"
+	// CORSRejectedHeaders means a header in Access-Control-Request-Headers
+	// is not allowed.
+	CORSRejectedHeaders CORSRejection = "headers"
+	// CORSRejectedPrivateNetwork means the preflight asked for Private
+	// Network Access, which the policy does not allow.
+	CORSRejectedPrivateNetwork CORSRejection = "private_network"
+)
+
+// checkPreflight returns the reason the preflight r must be rejected, or ""
+// if the policy allows it.
+func (p corsPolicy) checkPreflight(r *http.Request) CORSRejection {
+	// Check if the requested HTTP method is allowed
+	if method := r.Header.Get("Access-Control-Request-Method"); method != "" {
+		if _, ok := p.allowedMethodsSet[method]; !ok {
+			return CORSRejectedMethod
+		}
+	}
+
+	// Only the specifically configured headers are allowed, unless the
+	// wildcard is configured
+	requestHeaders := r.Header.Get("Access-Control-Request-Headers")
+	if requestHeaders != "" && !p.allowAnyHeader && !p.areHeadersAllowed(requestHeaders) {
+		return CORSRejectedHeaders
+	}
+
+	if r.Header.Get("Access-Control-Request-Private-Network") == "true" && !p.allowPrivateNetwork {
+		return CORSRejectedPrivateNetwork
+	}
+	return ""
+}
+
+// preflightHeaders sets the headers answering an allowed preflight.
+func preflightHeaders(w http.ResponseWriter, r *http.Request, policy corsPolicy) {
+	// Set the list of allowed HTTP methods
+	if policy.allowedMethodsHeader != "" {
+		w.Header().Set("Access-Control-Allow-Methods", policy.allowedMethodsHeader)
+	}
+
+	// If wildcard is configured for headers, mirror the requested headers
+	// This allows the browser to send any headers it needs
+	requestHeaders := r.Header.Get("Access-Control-Request-Headers")
+	if policy.allowAnyHeader {
+		if requestHeaders != "" {
+			w.Header().Set("Access-Control-Allow-Headers", requestHeaders)
+		}
+	} else if policy.allowedHeadersHeader != "" {
+		w.Header().Set("Access-Control-Allow-Headers", policy.allowedHeadersHeader)
+	}
+
+	// Answer Private Network Access preflights, identifying the device for
+	// the browser's permission prompt if configured
+	if r.Header.Get("Access-Control-Request-Private-Network") == "true" {
+		w.Header().Set("Access-Control-Allow-Private-Network", "true")
+		if policy.privateNetworkID != "" {
+			w.Header().Set("Private-Network-Access-ID", policy.privateNetworkID)
+		}
+		if policy.privateNetworkName != "" {
+			w.Header().Set("Private-Network-Access-Name", policy.privateNetworkName)
 		}
 	}
 }
@@ -251,7 +445,7 @@ func corsHeaders(w http.ResponseWriter, config corsPolicy, origin string) {
 	}
 }
 
-func buildPolicy(config CORSConfig) corsPolicy {
+func buildPolicy(config CORSConfig) (corsPolicy, error) {
 	policy := corsPolicy{
 		allowedMethods:    config.AllowedMethods,
 		allowedMethodsSet: make(map[string]struct{}, len(config.AllowedMethods)),
@@ -259,13 +453,31 @@ func buildPolicy(config CORSConfig) corsPolicy {
 		allowedHeaders:    config.AllowedHeaders,
 		allowedHeadersSet: make(map[string]struct{}, len(config.AllowedHeaders)),
 		allowedHeadersHeader: strings.Join(config.AllowedHeaders, ", "),
-		allowedOrigins:    make(map[string]struct{}, len(config.AllowedOrigins)),
+		allowedOrigins:    make(map[string]string, len(config.AllowedOrigins)),
 		allowAnyOrigin:    false,
 		allowAnyHeader:    containsWildcard(config.AllowedHeaders),
 		exposeHeaders:     config.ExposeHeaders,
 		exposeHeadersHeader: strings.Join(config.ExposeHeaders, ", "),
 		allowCredentials:  config.AllowCredentials,
 		maxAge:            config.MaxAge,
+		allowOriginFunc:   config.AllowOriginFunc,
+		allowPrivateNetwork: config.AllowPrivateNetwork,
+		privateNetworkID:   config.PrivateNetworkID,
+		privateNetworkName: config.PrivateNetworkName,
+		onDecision:         config.OnDecision,
+		debug:              config.Debug,
+	}
+
+	if err := validatePrivateNetworkIdentity(config.PrivateNetworkID, config.PrivateNetworkName); err != nil {
+		return corsPolicy{}, err
+	}
+
+	if policy.onDecision == nil {
+		policy.onDecision = logCORSDecisions(config.Logger, slog.LevelWarn)
+	}
+
+	if config.AllowOriginFunc != nil && config.OriginCacheTTL > 0 {
+		policy.originCache = newOriginCache(config.OriginCacheTTL)
 	}
 
 	for _, method := range config.AllowedMethods {
@@ -277,27 +489,9 @@ func buildPolicy(config CORSConfig) corsPolicy {
 	}
 
 	for _, origin := range config.AllowedOrigins {
-		if origin == "*" {
-			policy.allowAnyOrigin = true
-			continue
-		}
-
-		if strings.HasPrefix(origin, "https://*.") {
-			policy.allowedPatterns = append(policy.allowedPatterns, originPattern{
-				scheme: "https",
-				suffix: origin[len("https://*."):],
-			})
-			continue
-		}
-		if strings.HasPrefix(origin, "http://*.") {
-			policy.allowedPatterns = append(policy.allowedPatterns, originPattern{
-				scheme: "http",
-				suffix: origin[len("http://*."):],
-			})
-			continue
+		if err := policy.addOrigin(origin); err != nil {
+			return corsPolicy{}, err
 		}
-
-		policy.allowedOrigins[origin] = struct{}{}
 	}
 
 	// Invalid configuration: wildcard origin with credentials.
@@ -306,38 +500,122 @@ func buildPolicy(config CORSConfig) corsPolicy {
 		policy.allowAnyOrigin = false
 	}
 
-	return policy
+	return policy, nil
 }
 
 func (p corsPolicy) isOriginAllowed(origin string) bool {
+	return p.matchStaticOrigin(origin) != ""
+}
+
+// matchStaticOrigin returns the AllowedOrigins entry that allows origin, or
+// "" if none does.
+func (p corsPolicy) matchStaticOrigin(origin string) string {
+	// The opaque "null" origin is sent by sandboxed documents and local
+	// files, so it is only allowed when listed explicitly.
+	if origin == "null" {
+		if p.allowNullOrigin {
+			return "null"
+		}
+		return ""
+	}
 	if p.allowAnyOrigin {
-		return true
+		return "*"
 	}
-	if _, ok := p.allowedOrigins[origin]; ok {
-		return true
+	if entry, ok := p.allowedOrigins[origin]; ok {
+		return entry
 	}
-	if len(p.allowedPatterns) == 0 {
-		return false
+	if len(p.allowedOrigins) == 0 && len(p.allowedPatterns) == 0 && len(p.allowedRegexps) == 0 {
+		return ""
 	}
 
-	u, err := url.Parse(origin)
+	o, err := parseOrigin(origin)
 	if err != nil {
-		return false
+		return ""
 	}
-	host := u.Hostname()
-	if host == "" {
-		return false
+	normalized := o.String()
+	if entry, ok := p.allowedOrigins[normalized]; ok {
+		return entry
 	}
-
 	for _, pattern := range p.allowedPatterns {
-		if u.Scheme != pattern.scheme {
-			continue
+		if pattern.matches(o) {
+			return pattern.entry
+		}
+	}
+	for _, re := range p.allowedRegexps {
+		if re.MatchString(normalized) {
+			return re.entry
+		}
+	}
+	return ""
+}
+
+// matchOrigin returns the rule that allows origin to make requests like r:
+// an AllowedOrigins entry, or "AllowOriginFunc" if the callback allowed it.
+// It returns "" if the origin is not allowed, along with any error from
+// the callback.
+func (p corsPolicy) matchOrigin(r *http.Request, origin string) (string, error) {
+	if entry := p.matchStaticOrigin(origin); entry != "" {
+		return entry, nil
+	}
+	if p.allowOriginFunc == nil {
+		return "", nil
+	}
+	allowed, cached := false, false
+	if p.originCache != nil {
+		allowed, cached = p.originCache.get(origin)
+	}
+	if !cached {
+		var err error
+		if allowed, err = p.allowOriginFunc(r, origin); err != nil {
+			return "", err
 		}
-		if host != pattern.suffix && strings.HasSuffix(host, "."+pattern.suffix) {
-			return true
+		if p.originCache != nil {
+			p.originCache.set(origin, allowed)
 		}
 	}
-	return false
+	if !allowed {
+		return "", nil
+	}
+	return "AllowOriginFunc", nil
+}
+
+// maxOriginCacheEntries bounds the origin cache, since the Origin header is
+// chosen by the client.
+const maxOriginCacheEntries = 10000
+
+// originCache remembers AllowOriginFunc results for a fixed time.
+type originCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]originCacheEntry
+}
+
+type originCacheEntry struct {
+	allowed bool
+	expires time.Time
+}
+
+func newOriginCache(ttl time.Duration) *originCache {
+	return &originCache{ttl: ttl, entries: make(map[string]originCacheEntry)}
+}
+
+func (c *originCache) get(origin string) (allowed, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[origin]
+	if !ok || time.Now().After(entry.expires) {
+		return false, false
+	}
+	return entry.allowed, true
+}
+
+func (c *originCache) set(origin string, allowed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxOriginCacheEntries {
+		clear(c.entries)
+	}
+	c.entries[origin] = originCacheEntry{allowed: allowed, expires: time.Now().Add(c.ttl)}
 }
 
 func (p corsPolicy) areHeadersAllowed(requestHeaders string) bool {
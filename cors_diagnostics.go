@@ -0,0 +1,163 @@
+// Package intake provides HTTP routing utilities.
+// This file contains diagnostics for the CORS middleware: structured
+// decisions, a debug header and counters by rejection reason.
+package intake
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+)
+
+// CORSDecision describes how the CORS middleware handled a request that
+// carried an Origin header.
+type CORSDecision struct {
+	// Origin is the Origin header of the request
+	Origin string
+	// Method is the request method, or for preflights the method requested
+	// with Access-Control-Request-Method
+	Method string
+	// Preflight reports whether the request was a preflight
+	Preflight bool
+	// RequestHeaders is the Access-Control-Request-Headers value of a preflight
+	RequestHeaders string
+	// MatchedRule is the AllowedOrigins entry that allowed the origin, or
+	// "AllowOriginFunc"; empty if the origin was rejected
+	MatchedRule string
+	// Allowed reports whether the request was allowed
+	Allowed bool
+	// Rejection is the check the request failed; empty if it was allowed
+	Rejection CORSRejection
+	// Err is the error returned by AllowOriginFunc, if any
+	Err error
+}
+
+// String explains the decision in one line, as sent in the X-CORS-Debug
+// header.
+func (d CORSDecision) String() string {
+	switch d.Rejection {
+	case "":
+		return fmt.Sprintf("allowed: origin %q matched %q", d.Origin, d.MatchedRule)
+	case CORSRejectedOrigin:
+		if d.Err != nil {
+			return fmt.Sprintf("rejected: origin %q: AllowOriginFunc failed: %v", d.Origin, d.Err)
+		}
+		return fmt.Sprintf("rejected: origin %q is not allowed", d.Origin)
+	case CORSRejectedMethod:
+		return fmt.Sprintf("rejected: method %q is not allowed", d.Method)
+	case CORSRejectedHeaders:
+		return fmt.Sprintf("rejected: requested headers %q are not all allowed", d.RequestHeaders)
+	case CORSRejectedPrivateNetwork:
+		return "rejected: private network access is not allowed"
+	}
+	return fmt.Sprintf("rejected: %s", d.Rejection)
+}
+
+// corsRejections lists every rejection reason, in the order Stats reports them.
+var corsRejections = [...]CORSRejection{
+	CORSRejectedOrigin,
+	CORSRejectedMethod,
+	CORSRejectedHeaders,
+	CORSRejectedPrivateNetwork,
+}
+
+// corsCounters counts CORS decisions without locking.
+type corsCounters struct {
+	allowed  atomic.Uint64
+	rejected [len(corsRejections)]atomic.Uint64
+}
+
+func (c *corsCounters) add(d CORSDecision) {
+	if d.Allowed {
+		c.allowed.Add(1)
+		return
+	}
+	for i, reason := range corsRejections {
+		if reason == d.Rejection {
+			c.rejected[i].Add(1)
+		}
+	}
+}
+
+// CORSStats counts the decisions made by a CORSHandler since it was
+// created. Requests without an Origin header are not counted.
+type CORSStats struct {
+	// Allowed is the number of allowed requests, including preflights
+	Allowed uint64
+	// Rejected is the number of rejected requests by reason
+	Rejected map[CORSRejection]uint64
+}
+
+// Stats returns the number of requests the handler has allowed and
+// rejected, e.g. to export as metrics. Counts are kept across Update.
+//
+// Returns:
+//   - The current counts
+func (h *CORSHandler) Stats() CORSStats {
+	stats := CORSStats{
+		Allowed:  h.stats.allowed.Load(),
+		Rejected: make(map[CORSRejection]uint64, len(corsRejections)),
+	}
+	for i, reason := range corsRejections {
+		stats.Rejected[reason] = h.stats.rejected[i].Load()
+	}
+	return stats
+}
+
+// decide records d and reports it to the policy's diagnostics. It must be
+// called before the response header is written.
+func (h *CORSHandler) decide(w http.ResponseWriter, r *http.Request, policy corsPolicy, d CORSDecision) {
+	h.stats.add(d)
+	if policy.debug {
+		w.Header().Set("X-CORS-Debug", d.String())
+	}
+	if policy.onDecision != nil {
+		policy.onDecision(r, d)
+	}
+}
+
+// LogCORSDecisions returns an OnDecision hook that logs rejected requests
+// at Info level and allowed ones at Debug level.
+//
+// Parameters:
+//   - logger: The logger to write to, or nil for slog.Default()
+//
+// Returns:
+//   - A function to set as CORSConfig.OnDecision
+func LogCORSDecisions(logger *slog.Logger) func(*http.Request, CORSDecision) {
+	return logCORSDecisions(logger, slog.LevelInfo)
+}
+
+// logCORSDecisions returns an OnDecision hook that logs rejected requests at
+// rejected level and allowed ones at Debug level.
+func logCORSDecisions(logger *slog.Logger, rejected slog.Level) func(*http.Request, CORSDecision) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(r *http.Request, d CORSDecision) {
+		level, msg := slog.LevelDebug, "CORS request allowed"
+		if !d.Allowed {
+			level, msg = rejected, "CORS request rejected"
+		}
+		attrs := []slog.Attr{
+			slog.String("origin", d.Origin),
+			slog.String("method", d.Method),
+			slog.String("path", r.URL.Path),
+			slog.Bool("preflight", d.Preflight),
+		}
+		if d.RequestHeaders != "" {
+			attrs = append(attrs, slog.String("request_headers", d.RequestHeaders))
+		}
+		if d.MatchedRule != "" {
+			attrs = append(attrs, slog.String("matched_rule", d.MatchedRule))
+		}
+		if d.Rejection != "" {
+			attrs = append(attrs, slog.String("reason", string(d.Rejection)))
+		}
+		if d.Err != nil {
+			attrs = append(attrs, slog.String("error", d.Err.Error()))
+		}
+		logger.LogAttrs(r.Context(), level, msg, attrs...)
+	}
+}
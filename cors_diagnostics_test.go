@@ -0,0 +1,105 @@
+package intake
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCORSDiagnostics(t *testing.T) {
+	var decisions []CORSDecision
+	cors, err := NewCORSHandler(CORSConfig{
+		AllowedOrigins: []string{"https://*.example.com"},
+		AllowedMethods: []string{http.MethodGet},
+		AllowedHeaders: []string{"Content-Type"},
+		Debug:          true,
+		OnDecision: func(r *http.Request, d CORSDecision) {
+			decisions = append(decisions, d)
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	app := New()
+	app.AddGlobalMiddleware(cors.Middleware)
+	app.AddEndpoint(http.MethodGet, "/data", func(w http.ResponseWriter, r *http.Request) {})
+	app.AddOptionsEndpoints()
+
+	cases := []struct {
+		method, origin, requestMethod, requestHeaders string
+		rejection                                     CORSRejection
+		debug                                         string
+	}{
+		{http.MethodGet, "https://app.example.com", "", "", "", `allowed: origin "https://app.example.com" matched "https://*.example.com"`},
+		{http.MethodGet, "https://evil.example", "", "", CORSRejectedOrigin, `rejected: origin "https://evil.example" is not allowed`},
+		{http.MethodOptions, "https://app.example.com", http.MethodDelete, "", CORSRejectedMethod, `rejected: method "DELETE" is not allowed`},
+		{http.MethodOptions, "https://app.example.com", http.MethodGet, "X-Secret", CORSRejectedHeaders, `rejected: requested headers "X-Secret" are not all allowed`},
+		{http.MethodOptions, "https://app.example.com", http.MethodGet, "content-type", "", `allowed: origin "https://app.example.com" matched "https://*.example.com"`},
+	}
+	for i, tc := range cases {
+		req := httptest.NewRequest(tc.method, "/data", nil)
+		req.Header.Set("Origin", tc.origin)
+		if tc.requestMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", tc.requestMethod)
+		}
+		if tc.requestHeaders != "" {
+			req.Header.Set("Access-Control-Request-Headers", tc.requestHeaders)
+		}
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("X-CORS-Debug"); got != tc.debug {
+			t.Errorf("case %d: expected debug header %q, got %q", i, tc.debug, got)
+		}
+		if len(decisions) != i+1 {
+			t.Fatalf("case %d: expected one decision per request, got %d", i, len(decisions))
+		}
+		if d := decisions[i]; d.Rejection != tc.rejection || d.Allowed != (tc.rejection == "") {
+			t.Errorf("case %d: unexpected decision %+v", i, d)
+		}
+	}
+
+	stats := cors.Stats()
+	if stats.Allowed != 2 || stats.Rejected[CORSRejectedOrigin] != 1 || stats.Rejected[CORSRejectedMethod] != 1 ||
+		stats.Rejected[CORSRejectedHeaders] != 1 || stats.Rejected[CORSRejectedPrivateNetwork] != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestLogCORSDecisions(t *testing.T) {
+	var logs bytes.Buffer
+	hook := LogCORSDecisions(slog.New(slog.NewTextHandler(&logs, nil)))
+	req := httptest.NewRequest(http.MethodGet, "/data", nil)
+
+	hook(req, CORSDecision{Origin: "https://app.example.com", Method: http.MethodGet, Allowed: true})
+	if logs.Len() != 0 {
+		t.Fatalf("expected allowed requests to be logged at debug level, got %q", logs.String())
+	}
+	hook(req, CORSDecision{Origin: "https://evil.example", Method: http.MethodGet, Rejection: CORSRejectedOrigin})
+	if !strings.Contains(logs.String(), "reason=origin") || !strings.Contains(logs.String(), "origin=https://evil.example") {
+		t.Fatalf("expected the rejection to be logged, got %q", logs.String())
+	}
+}
+
+func TestCORSLogsRejectionsByDefault(t *testing.T) {
+	var logs bytes.Buffer
+	handler := CORS(CORSConfig{
+		AllowedOrigins: []string{"https://app.example.com"},
+		Logger:         slog.New(slog.NewTextHandler(&logs, nil)),
+	})(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, origin := range []string{"https://app.example.com", "https://evil.example"} {
+		req := httptest.NewRequest(http.MethodGet, "/data", nil)
+		req.Header.Set("Origin", origin)
+		handler(httptest.NewRecorder(), req)
+	}
+	if got := logs.String(); !strings.Contains(got, "level=WARN") || !strings.Contains(got, "origin=https://evil.example") {
+		t.Fatalf("expected the rejection to be logged at warn level, got %q", got)
+	}
+	if strings.Contains(logs.String(), "https://app.example.com") {
+		t.Fatalf("expected allowed requests to be logged at debug level, got %q", logs.String())
+	}
+}
@@ -0,0 +1,301 @@
+// Package intake provides HTTP routing utilities.
+// This file contains the parsing and matching of CORS origin patterns,
+// including port wildcards, regular expressions and internationalized
+// domain names.
+package intake
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// originPattern matches origins by scheme, host and port.
+type originPattern struct {
+	// entry is the AllowedOrigins entry the pattern was parsed from
+	entry  string
+	scheme string
+	// host is the host to match, or the parent domain if subdomains is set
+	host string
+	// subdomains matches any subdomain of host, at any depth, but not host itself
+	subdomains bool
+	// port is the port to match; empty means the scheme's default port
+	port string
+	// anyPort matches every port, including the default one
+	anyPort bool
+}
+
+// matches reports whether the parsed origin matches the pattern.
+func (p originPattern) matches(o parsedOrigin) bool {
+	if o.scheme != p.scheme || (!p.anyPort && o.port != p.port) {
+		return false
+	}
+	if p.subdomains {
+		return strings.HasSuffix(o.host, "."+p.host)
+	}
+	return o.host == p.host
+}
+
+// originRegexp is a regular expression from AllowedOrigins.
+type originRegexp struct {
+	*regexp.Regexp
+	entry string
+}
+
+// parsedOrigin is an origin in normalized form: a lowercase scheme, a
+// lowercase ASCII host and no default port.
+type parsedOrigin struct {
+	scheme, host, port string
+}
+
+func (o parsedOrigin) String() string {
+	if o.port == "" {
+		return o.scheme + "://" + o.host
+	}
+	return o.scheme + "://" + o.host + ":" + o.port
+}
+
+// parseOrigin parses and normalizes an origin of the form
+// scheme://host[:port]. Unicode host names are converted to punycode.
+func parseOrigin(origin string) (parsedOrigin, error) {
+	scheme, rest, ok := strings.Cut(origin, "://")
+	if !ok || !validScheme(scheme) {
+		return parsedOrigin{}, errors.New("expected scheme://host[:port]")
+	}
+	if rest == "" {
+		return parsedOrigin{}, errors.New("missing host")
+	}
+	if strings.ContainsAny(rest, "/?#@\\ ") {
+		return parsedOrigin{}, errors.New("an origin has no path, query or user info")
+	}
+
+	var host, port string
+	if strings.HasPrefix(rest, "[") {
+		end := strings.IndexByte(rest, ']')
+		if end < 0 {
+			return parsedOrigin{}, errors.New("unterminated IPv6 address")
+		}
+		host, rest = rest[:end+1], rest[end+1:]
+		if addr, err := netip.ParseAddr(host[1:end]); err != nil || !addr.Is6() {
+			return parsedOrigin{}, fmt.Errorf("invalid IPv6 address %q", host)
+		}
+		if rest != "" && !strings.HasPrefix(rest, ":") {
+			return parsedOrigin{}, errors.New("unexpected text after IPv6 address")
+		}
+		port = strings.TrimPrefix(rest, ":")
+	} else {
+		host, port, _ = strings.Cut(rest, ":")
+		var err error
+		if host, err = normalizeHost(host); err != nil {
+			return parsedOrigin{}, err
+		}
+	}
+	if strings.Contains(rest, ":") {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 || port[0] == '0' {
+			return parsedOrigin{}, fmt.Errorf("invalid port %q", port)
+		}
+	}
+
+	o := parsedOrigin{scheme: strings.ToLower(scheme), host: strings.ToLower(host), port: port}
+	if (o.scheme == "http" && port == "80") || (o.scheme == "https" && port == "443") {
+		o.port = ""
+	}
+	return o, nil
+}
+
+// validScheme reports whether s is a URL scheme as defined by RFC 3986.
+func validScheme(s string) bool {
+	for i, c := range s {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case i > 0 && ('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+// normalizeHost lowercases a DNS host name and converts its Unicode labels
+// to punycode.
+func normalizeHost(host string) (string, error) {
+	if host == "" {
+		return "", errors.New("missing host")
+	}
+	labels := strings.Split(strings.ToLower(host), ".")
+	for i, label := range labels {
+		if label == "" {
+			return "", fmt.Errorf("invalid host %q", host)
+		}
+		ascii := true
+		for _, c := range label {
+			if c >= 0x80 {
+				ascii = false
+			} else if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+				return "", fmt.Errorf("invalid host %q", host)
+			}
+		}
+		if ascii {
+			continue
+		}
+		encoded, err := punycodeEncode(label)
+		if err != nil {
+			return "", fmt.Errorf("invalid host %q: %v", host, err)
+		}
+		labels[i] = "xn--" + encoded
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// Punycode parameters from RFC 3492, section 5.
+const (
+	punycodeBase        = 36
+	punycodeTMin        = 1
+	punycodeTMax        = 26
+	punycodeSkew        = 38
+	punycodeDamp        = 700
+	punycodeInitialBias = 72
+	punycodeInitialN    = 128
+)
+
+// punycodeEncode encodes a Unicode label with the Punycode algorithm of
+// RFC 3492. The label is expected to be lowercase already; no other IDNA
+// mapping is applied.
+func punycodeEncode(label string) (string, error) {
+	runes := []rune(label)
+	out := make([]byte, 0, len(label))
+	for _, r := range runes {
+		if r < 0x80 {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	handled := basic
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := punycodeInitialN, 0, punycodeInitialBias
+	for handled < len(runes) {
+		m := math.MaxInt32
+		for _, r := range runes {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+		if (m - n) > (math.MaxInt32-delta)/(handled+1) {
+			return "", errors.New("punycode overflow")
+		}
+		delta += (m - n) * (handled + 1)
+		n = m
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+				if delta == math.MaxInt32 {
+					return "", errors.New("punycode overflow")
+				}
+			}
+			if int(r) != n {
+				continue
+			}
+			q := delta
+			for k := punycodeBase; ; k += punycodeBase {
+				t := k - bias
+				if t < punycodeTMin {
+					t = punycodeTMin
+				} else if t > punycodeTMax {
+					t = punycodeTMax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, punycodeDigit(t+(q-t)%(punycodeBase-t)))
+				q = (q - t) / (punycodeBase - t)
+			}
+			out = append(out, punycodeDigit(q))
+			bias = punycodeAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(out), nil
+}
+
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punycodeAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punycodeDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > ((punycodeBase-punycodeTMin)*punycodeTMax)/2 {
+		delta /= punycodeBase - punycodeTMin
+		k += punycodeBase
+	}
+	return k + (punycodeBase-punycodeTMin+1)*delta/(delta+punycodeSkew)
+}
+
+// addOrigin adds one AllowedOrigins entry to the policy. Entries are "*",
+// "null", a regular expression starting with "^", or an origin whose host
+// may start with "*." and whose port may be "*".
+func (p *corsPolicy) addOrigin(entry string) error {
+	switch {
+	case entry == "":
+		return errors.New("empty origin")
+	case entry == "*":
+		p.allowAnyOrigin = true
+		return nil
+	case entry == "null":
+		p.allowNullOrigin = true
+		return nil
+	case strings.HasPrefix(entry, "^"):
+		expr := strings.TrimSuffix(strings.TrimPrefix(entry, "^"), "$")
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return fmt.Errorf("origin %q: %v", entry, err)
+		}
+		p.allowedRegexps = append(p.allowedRegexps, originRegexp{Regexp: re, entry: entry})
+		return nil
+	}
+
+	rest, anyPort := strings.CutSuffix(entry, ":*")
+	scheme, host, ok := strings.Cut(rest, "://")
+	if !ok {
+		return fmt.Errorf("origin %q: expected scheme://host[:port]", entry)
+	}
+	host, subdomains := strings.CutPrefix(host, "*.")
+	o, err := parseOrigin(scheme + "://" + host)
+	if err != nil {
+		return fmt.Errorf("origin %q: %v", entry, err)
+	}
+	if !subdomains && !anyPort {
+		p.allowedOrigins[o.String()] = entry
+		return nil
+	}
+	if anyPort && o.port != "" {
+		return fmt.Errorf("origin %q: both a port and a port wildcard are set", entry)
+	}
+	p.allowedPatterns = append(p.allowedPatterns, originPattern{
+		entry:      entry,
+		scheme:     o.scheme,
+		host:       o.host,
+		subdomains: subdomains,
+		port:       o.port,
+		anyPort:    anyPort,
+	})
+	return nil
+}
@@ -0,0 +1,121 @@
+package intake
+
+import (
+	"testing"
+)
+
+func TestPunycodeEncode(t *testing.T) {
+	cases := map[string]string{
+		"münchen": "mnchen-3ya",
+		"bücher":  "bcher-kva",
+		"例え":      "r8jz45g",
+		"ü":       "tda",
+	}
+	for label, want := range cases {
+		got, err := punycodeEncode(label)
+		if err != nil || got != want {
+			t.Errorf("%s: expected %q, got %q, %v", label, want, got, err)
+		}
+	}
+}
+
+func TestParseOrigin(t *testing.T) {
+	cases := map[string]string{
+		"https://Example.COM":         "https://example.com",
+		"HTTPS://example.com:443":     "https://example.com",
+		"http://example.com:8080":     "http://example.com:8080",
+		"https://bücher.example":      "https://xn--bcher-kva.example",
+		"http://[2001:DB8::1]:3000":   "http://[2001:db8::1]:3000",
+		"chrome-extension://abcdefgh": "chrome-extension://abcdefgh",
+	}
+	for origin, want := range cases {
+		o, err := parseOrigin(origin)
+		if err != nil || o.String() != want {
+			t.Errorf("%s: expected %q, got %q, %v", origin, want, o.String(), err)
+		}
+	}
+
+	for _, origin := range []string{"", "example.com", "https://", "https://example.com/", "https://user@example.com", "https://example.com:0", "https://example.com:99999", "https://exa mple.com", "http://[::1"} {
+		if _, err := parseOrigin(origin); err == nil {
+			t.Errorf("%q: expected an error", origin)
+		}
+	}
+}
+
+func TestCORSOriginPatterns(t *testing.T) {
+	policy, err := buildPolicy(CORSConfig{
+		AllowedOrigins: []string{
+			"https://App.Example.com",
+			"https://*.example.org",
+			"http://localhost:*",
+			"https://*.staging.example.net:*",
+			`^https://pr-[0-9]+\.preview\.example\.io$`,
+			"https://bücher.example",
+			"null",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := map[string]bool{
+		"https://app.example.com":               true,
+		"https://APP.example.com:443":           true,
+		"http://app.example.com":                false,
+		"https://a.b.example.org":               true,
+		"https://example.org":                   false,
+		"https://example.org.evil.com":          false,
+		"http://localhost":                      true,
+		"http://localhost:5173":                 true,
+		"https://localhost:5173":                false,
+		"https://api.staging.example.net:8443":  true,
+		"https://pr-42.preview.example.io":      true,
+		"https://pr-42.preview.example.io.evil": false,
+		"https://pr-x.preview.example.io":       false,
+		"https://xn--bcher-kva.example":         true,
+		"null":                                  true,
+		"not an origin":                         false,
+	}
+	for origin, want := range cases {
+		if got := policy.isOriginAllowed(origin); got != want {
+			t.Errorf("%s: expected %v, got %v", origin, want, got)
+		}
+	}
+
+	wildcard, _ := buildPolicy(CORSConfig{AllowedOrigins: []string{"*"}})
+	if wildcard.isOriginAllowed("null") {
+		t.Errorf("expected \"*\" not to allow the null origin")
+	}
+}
+
+func TestCORSInvalidOrigins(t *testing.T) {
+	for _, origin := range []string{
+		"",
+		"example.com",
+		"https://example.com/",
+		"https://api.*.example.com",
+		"https://*example.com",
+		"http://localhost:3000:*",
+		"^https://(unclosed$",
+	} {
+		if _, err := buildPolicy(CORSConfig{AllowedOrigins: []string{origin}}); err == nil {
+			t.Errorf("%q: expected an error", origin)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected CORS to panic on an invalid origin")
+		}
+	}()
+	CORS(CORSConfig{AllowedOrigins: []string{"https://example.com/path"}})
+}
+
+func TestCSRFInvalidTrustedOrigins(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected CSRF to panic on an invalid trusted origin")
+		}
+	}()
+	CSRF(CSRFConfig{Mode: CSRFFetchMetadata, TrustedOrigins: []string{"https://example.com/"}})
+}
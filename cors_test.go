@@ -1,9 +1,13 @@
 package intake
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestCORSPreflightHeaderValidation(t *testing.T) {
@@ -116,3 +120,217 @@ func TestCORSWildcardOriginSchemeMatch(t *testing.T) {
 		}
 	})
 }
+
+func TestCORSAllowOriginFunc(t *testing.T) {
+	var calls atomic.Int32
+	app := New()
+	app.AddGlobalMiddleware(CORS(CORSConfig{
+		AllowedOrigins: []string{"https://static.example.com"},
+		AllowOriginFunc: func(r *http.Request, origin string) (bool, error) {
+			calls.Add(1)
+			switch origin {
+			case "https://tenant.example.net":
+				return true, nil
+			case "https://broken.example.net":
+				return true, errors.New("lookup failed")
+			}
+			return false, nil
+		},
+		OriginCacheTTL: time.Minute,
+	}))
+	app.AddEndpoint(http.MethodGet, "/data", func(w http.ResponseWriter, r *http.Request) {})
+
+	allowOrigin := func(origin string) string {
+		req := httptest.NewRequest(http.MethodGet, "/data", nil)
+		req.Header.Set("Origin", origin)
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+		return rr.Header().Get("Access-Control-Allow-Origin")
+	}
+
+	if got := allowOrigin("https://static.example.com"); got != "https://static.example.com" {
+		t.Fatalf("expected static origin to be allowed, got %q", got)
+	}
+	if calls.Load() != 0 {
+		t.Fatalf("expected the callback not to be consulted for static origins")
+	}
+	for i := 0; i < 3; i++ {
+		if got := allowOrigin("https://tenant.example.net"); got != "https://tenant.example.net" {
+			t.Fatalf("expected dynamic origin to be allowed, got %q", got)
+		}
+		if got := allowOrigin("https://other.example.net"); got != "" {
+			t.Fatalf("expected unknown origin to be rejected, got %q", got)
+		}
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("expected results to be cached, got %d calls", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		if got := allowOrigin("https://broken.example.net"); got != "" {
+			t.Fatalf("expected an error to reject the origin, got %q", got)
+		}
+	}
+	if got := calls.Load(); got != 4 {
+		t.Fatalf("expected errors not to be cached, got %d calls", got)
+	}
+}
+
+func TestCORSHandlerUpdate(t *testing.T) {
+	cors, err := NewCORSHandler(CORSConfig{AllowedOrigins: []string{"https://old.example.com"}})
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	app := New()
+	app.AddGlobalMiddleware(cors.Middleware)
+	app.AddEndpoint(http.MethodGet, "/data", func(w http.ResponseWriter, r *http.Request) {})
+
+	allowOrigin := func(origin string) string {
+		req := httptest.NewRequest(http.MethodGet, "/data", nil)
+		req.Header.Set("Origin", origin)
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+		return rr.Header().Get("Access-Control-Allow-Origin")
+	}
+
+	if got := allowOrigin("https://old.example.com"); got != "https://old.example.com" {
+		t.Fatalf("expected old origin to be allowed, got %q", got)
+	}
+	if err := cors.Update(CORSConfig{AllowedOrigins: []string{"https://new.example.com"}}); err != nil {
+		t.Fatalf("failed to update policy: %v", err)
+	}
+	if err := cors.Update(CORSConfig{AllowedOrigins: []string{"new.example.com"}}); err == nil {
+		t.Fatalf("expected an invalid update to fail")
+	}
+	if got := allowOrigin("https://old.example.com"); got != "" {
+		t.Fatalf("expected old origin to be rejected after update, got %q", got)
+	}
+	if got := allowOrigin("https://new.example.com"); got != "https://new.example.com" {
+		t.Fatalf("expected new origin to be allowed after update, got %q", got)
+	}
+}
+
+func TestCORSPerRoute(t *testing.T) {
+	const frontend = "https://app.example.com"
+
+	app := New()
+	app.AddGlobalMiddleware(CORS(CORSConfig{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{http.MethodGet},
+	}))
+	account := Endpoints{
+		GET("/account", func(w http.ResponseWriter, r *http.Request) {}),
+		POST("/items", func(w http.ResponseWriter, r *http.Request) {}),
+	}
+	account.With(WithCORS(CORSConfig{
+		AllowedOrigins:   []string{frontend},
+		AllowedMethods:   []string{http.MethodGet, http.MethodHead, http.MethodPost},
+		AllowCredentials: true,
+	}))
+	app.AddEndpoints(account)
+	app.AddEndpoint(http.MethodGet, "/items", func(w http.ResponseWriter, r *http.Request) {})
+	// An explicit HEAD route keeps its own policy instead of the GET one.
+	app.AddEndpoints(Endpoints{
+		GET("/reports", func(w http.ResponseWriter, r *http.Request) {}).With(WithCORS(CORSConfig{
+			AllowedOrigins: []string{frontend},
+			AllowedMethods: []string{http.MethodGet, http.MethodHead},
+		})),
+		HEAD("/reports", func(w http.ResponseWriter, r *http.Request) {}).With(WithCORS(CORSConfig{
+			AllowedOrigins: []string{"https://monitor.example.com"},
+			AllowedMethods: []string{http.MethodHead},
+		})),
+		GET("/exports", func(w http.ResponseWriter, r *http.Request) {}).With(WithCORS(CORSConfig{
+			AllowedOrigins:   []string{frontend},
+			AllowedMethods:   []string{http.MethodGet, http.MethodHead},
+			AllowCredentials: true,
+		})),
+		HEAD("/exports", func(w http.ResponseWriter, r *http.Request) {}),
+	})
+	app.AddOptionsEndpoints()
+
+	cases := []struct {
+		name        string
+		method      string
+		path        string
+		origin      string
+		want        string
+		credentials bool
+	}{
+		{"public route", http.MethodGet, "/items", "https://evil.example", "*", false},
+		{"account route, foreign origin", http.MethodGet, "/account", "https://evil.example", "", false},
+		{"account route, frontend", http.MethodGet, "/account", frontend, frontend, true},
+		{"preflight for public route", http.MethodOptions + " " + http.MethodGet, "/items", "https://evil.example", "*", false},
+		{"preflight for account route, foreign origin", http.MethodOptions + " " + http.MethodPost, "/items", "https://evil.example", "", false},
+		{"preflight for account route, frontend", http.MethodOptions + " " + http.MethodPost, "/items", frontend, frontend, true},
+		{"HEAD preflight for account route, frontend", http.MethodOptions + " " + http.MethodHead, "/account", frontend, frontend, true},
+		{"HEAD preflight for account route, foreign origin", http.MethodOptions + " " + http.MethodHead, "/account", "https://evil.example", "", false},
+		{"HEAD preflight for explicit HEAD route, GET origin", http.MethodOptions + " " + http.MethodHead, "/reports", frontend, "", false},
+		{"HEAD preflight for explicit HEAD route, HEAD origin", http.MethodOptions + " " + http.MethodHead, "/reports", "https://monitor.example.com", "https://monitor.example.com", false},
+		{"HEAD preflight for explicit HEAD route without policy", http.MethodOptions + " " + http.MethodHead, "/exports", frontend, "*", false},
+	}
+	for _, tc := range cases {
+		method, requestMethod, _ := strings.Cut(tc.method, " ")
+		req := httptest.NewRequest(method, tc.path, nil)
+		req.Header.Set("Origin", tc.origin)
+		if requestMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", requestMethod)
+		}
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tc.want {
+			t.Errorf("%s: expected Access-Control-Allow-Origin %q, got %q", tc.name, tc.want, got)
+		}
+		if got := rr.Header().Get("Access-Control-Allow-Credentials") == "true"; got != tc.credentials {
+			t.Errorf("%s: expected credentials %v, got %v", tc.name, tc.credentials, got)
+		}
+	}
+}
+
+func TestCORSPrivateNetworkAccess(t *testing.T) {
+	preflight := func(app *Intake) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/devices", nil)
+		req.Header.Set("Origin", "https://dashboard.example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		req.Header.Set("Access-Control-Request-Private-Network", "true")
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+		return rr
+	}
+	newApp := func(config CORSConfig) *Intake {
+		config.AllowedOrigins = []string{"https://dashboard.example.com"}
+		config.AllowedMethods = []string{http.MethodGet}
+		app := New()
+		app.AddGlobalMiddleware(CORS(config))
+		app.AddEndpoint(http.MethodGet, "/devices", func(w http.ResponseWriter, r *http.Request) {})
+		app.AddOptionsEndpoints()
+		return app
+	}
+
+	rr := preflight(newApp(CORSConfig{}))
+	if rr.Code != http.StatusForbidden || rr.Header().Get("Access-Control-Allow-Private-Network") != "" {
+		t.Fatalf("expected private network access to be rejected, got %d %v", rr.Code, rr.Header())
+	}
+
+	rr = preflight(newApp(CORSConfig{
+		AllowPrivateNetwork: true,
+		PrivateNetworkID:    "01:23:45:67:89:AB",
+		PrivateNetworkName:  "office-printer",
+	}))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, rr.Code)
+	}
+	for header, want := range map[string]string{
+		"Access-Control-Allow-Private-Network": "true",
+		"Private-Network-Access-ID":            "01:23:45:67:89:AB",
+		"Private-Network-Access-Name":          "office-printer",
+	} {
+		if got := rr.Header().Get(header); got != want {
+			t.Errorf("expected %s %q, got %q", header, want, got)
+		}
+	}
+
+	if _, err := buildPolicy(CORSConfig{AllowPrivateNetwork: true, PrivateNetworkID: "0123456789AB"}); err == nil {
+		t.Fatalf("expected an invalid private network ID to be rejected")
+	}
+}
@@ -0,0 +1,170 @@
+// Package intake provides HTTP routing utilities.
+// This file contains validation of CORS configurations, reporting both
+// invalid settings and legal but risky ones.
+package intake
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Validate reports every problem with the configuration, joined into one
+// error, or nil if it is valid. Unlike CORS, which ignores a "*" origin when
+// credentials are allowed, Validate rejects that combination.
+//
+// Returns:
+//   - An error listing every invalid setting, or nil
+func (c CORSConfig) Validate() error {
+	var errs []error
+	scratch := corsPolicy{allowedOrigins: make(map[string]string)}
+	for _, origin := range c.AllowedOrigins {
+		if err := scratch.addOrigin(origin); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.AllowCredentials && scratch.allowAnyOrigin {
+		errs = append(errs, errors.New(`the "*" origin cannot be used with AllowCredentials; list the allowed origins instead`))
+	}
+
+	for _, method := range c.AllowedMethods {
+		switch {
+		case !isHTTPToken(method):
+			errs = append(errs, fmt.Errorf("method %q is not a valid HTTP method", method))
+		case method != strings.ToUpper(method):
+			errs = append(errs, fmt.Errorf("method %q must be uppercase, since methods are case-sensitive", method))
+		}
+	}
+	for _, header := range c.AllowedHeaders {
+		if header != "*" && !isHTTPToken(header) {
+			errs = append(errs, fmt.Errorf("allowed header %q is not a valid header name", header))
+		}
+	}
+	for _, header := range c.ExposeHeaders {
+		if header != "*" && !isHTTPToken(header) {
+			errs = append(errs, fmt.Errorf("exposed header %q is not a valid header name", header))
+		}
+	}
+
+	if err := validatePrivateNetworkIdentity(c.PrivateNetworkID, c.PrivateNetworkName); err != nil {
+		errs = append(errs, err)
+	}
+	if c.MaxAge < 0 {
+		errs = append(errs, fmt.Errorf("MaxAge %d is negative", c.MaxAge))
+	}
+	if c.OriginCacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("OriginCacheTTL %v is negative", c.OriginCacheTTL))
+	}
+	if c.OriginCacheTTL > 0 && c.AllowOriginFunc == nil {
+		errs = append(errs, errors.New("OriginCacheTTL is set without AllowOriginFunc"))
+	}
+	return errors.Join(errs...)
+}
+
+// Warnings describes settings that are valid but risky or ineffective, such
+// as reflecting every requested header on credentialed requests.
+//
+// Returns:
+//   - One message per risky setting, or nil
+func (c CORSConfig) Warnings() []string {
+	var warnings []string
+	anyOrigin := false
+	for _, origin := range c.AllowedOrigins {
+		switch {
+		case origin == "*":
+			anyOrigin = true
+		case origin == "null":
+			warnings = append(warnings, `the "null" origin is shared by sandboxed documents from every site`)
+		case c.AllowCredentials && strings.HasPrefix(strings.ToLower(origin), "http://") && !isLoopbackOrigin(origin):
+			warnings = append(warnings, fmt.Sprintf("origin %q is not served over HTTPS but may send credentials", origin))
+		}
+	}
+	if c.AllowCredentials && containsWildcard(c.AllowedHeaders) {
+		warnings = append(warnings, "AllowedHeaders reflects every requested header on credentialed requests")
+	}
+	if c.AllowCredentials && containsWildcard(c.ExposeHeaders) {
+		warnings = append(warnings, `browsers treat a "*" in ExposeHeaders literally on credentialed requests`)
+	}
+	if anyOrigin && c.AllowPrivateNetwork {
+		warnings = append(warnings, `AllowPrivateNetwork with the "*" origin lets any public website reach this private service`)
+	}
+	if (c.PrivateNetworkID != "" || c.PrivateNetworkName != "") && !c.AllowPrivateNetwork {
+		warnings = append(warnings, "PrivateNetworkID and PrivateNetworkName are only sent with AllowPrivateNetwork")
+	}
+	if c.Debug {
+		warnings = append(warnings, "Debug explains every CORS decision in a response header; disable it in production")
+	}
+	if anyOrigin && c.AllowOriginFunc != nil {
+		warnings = append(warnings, `AllowOriginFunc is never consulted because the "*" origin allows every origin`)
+	}
+	return warnings
+}
+
+// NewCORS is like CORS but validates config first, returning every problem
+// found instead of silently correcting it. Warnings are logged through
+// slog.Default.
+//
+// Parameters:
+//   - config: The CORSConfig struct containing CORS policy configuration
+//
+// Returns:
+//   - A MiddleWare function that can be applied to HTTP handlers
+//   - An error listing every invalid setting
+func NewCORS(config CORSConfig) (MiddleWare, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	for _, warning := range config.Warnings() {
+		slog.Warn("risky CORS configuration", "warning", warning)
+	}
+	h, err := NewCORSHandler(config)
+	if err != nil {
+		return nil, err
+	}
+	return h.Middleware, nil
+}
+
+// privateNetworkIDPattern and privateNetworkNamePattern are the formats
+// Chrome accepts for the Private-Network-Access-ID and -Name headers.
+var (
+	privateNetworkIDPattern   = regexp.MustCompile(`^[0-9A-Fa-f]{2}(:[0-9A-Fa-f]{2}){5}$`)
+	privateNetworkNamePattern = regexp.MustCompile(`^[a-z0-9_.-]{1,248}$`)
+)
+
+// validatePrivateNetworkIdentity checks the optional device ID and name
+// sent with Private Network Access preflights.
+func validatePrivateNetworkIdentity(id, name string) error {
+	if id != "" && !privateNetworkIDPattern.MatchString(id) {
+		return fmt.Errorf("PrivateNetworkID %q must be six colon-separated hex bytes", id)
+	}
+	if name != "" && !privateNetworkNamePattern.MatchString(name) {
+		return fmt.Errorf("PrivateNetworkName %q must be 1 to 248 characters of [a-z0-9_.-]", name)
+	}
+	return nil
+}
+
+// isHTTPToken reports whether s is a token as defined by RFC 9110, the
+// syntax of method and header names.
+func isHTTPToken(s string) bool {
+	for _, c := range s {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+// isLoopbackOrigin reports whether origin points at the local machine,
+// where plain HTTP is acceptable.
+func isLoopbackOrigin(origin string) bool {
+	o, err := parseOrigin(strings.TrimSuffix(origin, ":*"))
+	if err != nil {
+		return false
+	}
+	return o.host == "localhost" || o.host == "127.0.0.1" || o.host == "[::1]"
+}
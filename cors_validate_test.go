@@ -0,0 +1,67 @@
+package intake
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCORSConfigValidate(t *testing.T) {
+	if err := DefaultCORSConfig().Validate(); err != nil {
+		t.Fatalf("expected the default config to be valid, got %v", err)
+	}
+
+	err := CORSConfig{
+		AllowedOrigins:   []string{"*", "", "example.com"},
+		AllowedMethods:   []string{http.MethodGet, "post", "BAD METHOD"},
+		AllowedHeaders:   []string{"X-Token", "X Bad"},
+		ExposeHeaders:    []string{"X-Ok:"},
+		AllowCredentials: true,
+		MaxAge:           -1,
+		OriginCacheTTL:   time.Minute,
+	}.Validate()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	for _, want := range []string{
+		"empty origin",
+		`origin "example.com"`,
+		`"*" origin cannot be used with AllowCredentials`,
+		`method "post" must be uppercase`,
+		`method "BAD METHOD" is not a valid`,
+		`allowed header "X Bad"`,
+		`exposed header "X-Ok:"`,
+		"MaxAge -1 is negative",
+		"OriginCacheTTL is set without AllowOriginFunc",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected the error to mention %q, got:\n%v", want, err)
+		}
+	}
+}
+
+func TestCORSConfigWarnings(t *testing.T) {
+	if warnings := DefaultCORSConfig().Warnings(); len(warnings) != 0 {
+		t.Fatalf("expected no warnings for the default config, got %v", warnings)
+	}
+
+	warnings := CORSConfig{
+		AllowedOrigins:   []string{"http://app.example.com", "http://localhost:*", "null"},
+		AllowedHeaders:   []string{"*"},
+		AllowCredentials: true,
+	}.Warnings()
+	if len(warnings) != 3 {
+		t.Fatalf("expected 3 warnings, got %q", warnings)
+	}
+}
+
+func TestNewCORS(t *testing.T) {
+	if _, err := NewCORS(CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}); err == nil {
+		t.Fatalf("expected NewCORS to reject a wildcard origin with credentials")
+	}
+	mw, err := NewCORS(CORSConfig{AllowedOrigins: []string{"https://example.com"}, AllowedMethods: []string{http.MethodGet}})
+	if err != nil || mw == nil {
+		t.Fatalf("expected a middleware, got %v", err)
+	}
+}
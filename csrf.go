@@ -0,0 +1,270 @@
+// Package intake provides HTTP routing utilities.
+// This file contains middleware that protects cookie-authenticated routes
+// against Cross-Site Request Forgery (CSRF).
+package intake
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+)
+
+// CSRFMode selects how the CSRF middleware tells genuine requests from
+// forged ones.
+type CSRFMode int
+
+const (
+	// CSRFDoubleSubmit sets a random token in a cookie and requires unsafe
+	// requests to echo it in a header or form field. A cross-site page can
+	// make the browser send the cookie but cannot read it.
+	CSRFDoubleSubmit CSRFMode = iota
+	// CSRFSynchronizer issues tokens that are HMACs bound to the caller's
+	// session, so a token is only valid for the session it was issued to.
+	CSRFSynchronizer
+	// CSRFFetchMetadata rejects unsafe cross-origin requests based on the
+	// Sec-Fetch-Site and Origin headers sent by browsers. It needs no token.
+	CSRFFetchMetadata
+)
+
+// csrfTokenBytes is the size of the random part of a token.
+const csrfTokenBytes = 32
+
+// CSRFConfig defines the configuration options for the CSRF middleware.
+type CSRFConfig struct {
+	// Mode selects the protection strategy. Default value is CSRFDoubleSubmit.
+	Mode CSRFMode
+
+	// Secret signs synchronizer tokens. If empty, a random secret is
+	// generated, so tokens do not survive restarts or span instances.
+	Secret []byte
+
+	// SessionID returns the identifier of the caller's session, which
+	// synchronizer tokens are bound to. Required in CSRFSynchronizer mode.
+	SessionID func(r *http.Request) string
+
+	// TrustedOrigins lists origins allowed to make unsafe cross-origin
+	// requests in CSRFFetchMetadata mode, using the same patterns as
+	// CORSConfig.AllowedOrigins, e.g. "https://*.example.com". Malformed
+	// entries make CSRF panic.
+	TrustedOrigins []string
+
+	// HeaderName is the request header carrying the token. Default value is
+	// "X-CSRF-Token".
+	HeaderName string
+
+	// FormField is the form field carrying the token when the header is not
+	// set. Default value is "csrf_token".
+	FormField string
+
+	// CookieName is the cookie holding the double-submit token. Default
+	// value is "csrf_token".
+	CookieName string
+
+	// CookiePath and CookieDomain scope the double-submit cookie. Default
+	// path is "/".
+	CookiePath   string
+	CookieDomain string
+
+	// InsecureCookie omits the Secure attribute from the double-submit
+	// cookie, for local development over plain HTTP.
+	InsecureCookie bool
+}
+
+// DefaultCSRFConfig returns a CSRF configuration with common settings.
+// The default configuration:
+// - Uses the double-submit cookie mode
+// - Reads the token from the X-CSRF-Token header or the csrf_token form field
+// - Stores the token in a Secure, SameSite=Lax csrf_token cookie
+func DefaultCSRFConfig() CSRFConfig {
+	return CSRFConfig{
+		Mode:       CSRFDoubleSubmit,
+		HeaderName: "X-CSRF-Token",
+		FormField:  "csrf_token",
+		CookieName: "csrf_token",
+		CookiePath: "/",
+	}
+}
+
+type csrfTokenKey struct{}
+
+// CSRFToken returns the token to embed in forms or send in the CSRF header
+// for requests made from the page being rendered. It is empty in
+// CSRFFetchMetadata mode.
+//
+//	<input type="hidden" name="csrf_token" value="{{ .CSRFToken }}">
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfTokenKey{}).(string)
+	return token
+}
+
+// WithoutCSRF exempts a route from the CSRF middleware, e.g. for an endpoint
+// authenticated by other means such as a webhook signature.
+func WithoutCSRF() EndpointOption {
+	return func(o *routeOptions) {
+		o.noCSRF = true
+	}
+}
+
+// CSRF returns a middleware that rejects forged unsafe requests with 403
+// Forbidden. Safe methods (GET, HEAD, OPTIONS and TRACE) are never checked;
+// they receive a token through CSRFToken so pages can include it in the
+// requests they make next. Routes marked with WithoutCSRF are not checked.
+// It panics if a TrustedOrigins entry is malformed.
+//
+// Parameters:
+//   - config: The CSRFConfig struct containing the CSRF configuration
+//
+// Returns:
+//   - A MiddleWare function that can be applied to HTTP handlers
+func CSRF(config CSRFConfig) MiddleWare {
+	defaults := DefaultCSRFConfig()
+	if config.HeaderName == "" {
+		config.HeaderName = defaults.HeaderName
+	}
+	if config.FormField == "" {
+		config.FormField = defaults.FormField
+	}
+	if config.CookieName == "" {
+		config.CookieName = defaults.CookieName
+	}
+	if config.CookiePath == "" {
+		config.CookiePath = defaults.CookiePath
+	}
+	if config.Mode == CSRFSynchronizer && config.SessionID == nil {
+		panic("intake: CSRF synchronizer mode requires SessionID")
+	}
+	if len(config.Secret) == 0 {
+		config.Secret = make([]byte, 32)
+		rand.Read(config.Secret)
+	}
+	trusted, err := buildPolicy(CORSConfig{AllowedOrigins: config.TrustedOrigins})
+	if err != nil {
+		panic("intake: CSRF: " + err.Error())
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if opts := routeOptionsFrom(r); opts != nil && opts.noCSRF {
+				next(w, r)
+				return
+			}
+			safe := false
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+				safe = true
+			}
+
+			var token string
+			switch config.Mode {
+			case CSRFFetchMetadata:
+				if !safe && !sameOriginRequest(r, trusted) {
+					http.Error(w, "cross-origin request rejected", http.StatusForbidden)
+					return
+				}
+
+			case CSRFSynchronizer:
+				session := config.SessionID(r)
+				if !safe && !validSynchronizerToken(config.Secret, session, csrfTokenFromRequest(r, config)) {
+					http.Error(w, "invalid CSRF token", http.StatusForbidden)
+					return
+				}
+				token = newSynchronizerToken(config.Secret, session)
+
+			default:
+				if cookie, err := r.Cookie(config.CookieName); err == nil && len(cookie.Value) > 0 {
+					token = cookie.Value
+				}
+				if !safe {
+					sent := csrfTokenFromRequest(r, config)
+					if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(sent)) != 1 {
+						http.Error(w, "invalid CSRF token", http.StatusForbidden)
+						return
+					}
+				}
+				if token == "" {
+					token = newCSRFRandom()
+					http.SetCookie(w, &http.Cookie{
+						Name:     config.CookieName,
+						Value:    token,
+						Path:     config.CookiePath,
+						Domain:   config.CookieDomain,
+						Secure:   !config.InsecureCookie,
+						SameSite: http.SameSiteLaxMode,
+					})
+				}
+			}
+
+			if token != "" {
+				r = r.WithContext(context.WithValue(r.Context(), csrfTokenKey{}, token))
+			}
+			next(w, r)
+		}
+	}
+}
+
+// csrfTokenFromRequest returns the token sent in the CSRF header or, failing
+// that, the form field.
+func csrfTokenFromRequest(r *http.Request, config CSRFConfig) string {
+	if token := r.Header.Get(config.HeaderName); token != "" {
+		return token
+	}
+	return r.PostFormValue(config.FormField)
+}
+
+// sameOriginRequest reports whether r was made by a page of the same origin
+// or of a trusted origin, judging by the headers browsers attach. Requests
+// with neither Sec-Fetch-Site nor Origin do not come from a browser and are
+// allowed.
+func sameOriginRequest(r *http.Request, trusted corsPolicy) bool {
+	origin := r.Header.Get("Origin")
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "":
+		if origin == "" {
+			return true
+		}
+		if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+			return true
+		}
+	}
+	return origin != "" && trusted.isOriginAllowed(origin)
+}
+
+// newCSRFRandom returns a random base64url token.
+func newCSRFRandom() string {
+	b := make([]byte, csrfTokenBytes)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// newSynchronizerToken returns a fresh token bound to session: a random
+// value followed by its HMAC with the session ID.
+func newSynchronizerToken(secret []byte, session string) string {
+	random := make([]byte, csrfTokenBytes)
+	rand.Read(random)
+	return base64.RawURLEncoding.EncodeToString(append(random, synchronizerMAC(secret, session, random)...))
+}
+
+// validSynchronizerToken reports whether token was issued for session.
+func validSynchronizerToken(secret []byte, session, token string) bool {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) != csrfTokenBytes+sha256.Size {
+		return false
+	}
+	random, mac := raw[:csrfTokenBytes], raw[csrfTokenBytes:]
+	return hmac.Equal(mac, synchronizerMAC(secret, session, random))
+}
+
+func synchronizerMAC(secret []byte, session string, random []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(session))
+	mac.Write([]byte{0})
+	mac.Write(random)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,131 @@
+package intake
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CSRFToken(r)))
+	}
+
+	t.Run("double submit", func(t *testing.T) {
+		app := New()
+		app.AddGlobalMiddleware(CSRF(CSRFConfig{}))
+		app.AddEndpoints(Endpoints{
+			GET("/form", ok),
+			POST("/submit", ok),
+			POST("/hook", ok).With(WithoutCSRF()),
+		})
+
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/form", nil))
+		cookies := rr.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != "csrf_token" || !cookies[0].Secure {
+			t.Fatalf("expected a secure csrf_token cookie, got %v", cookies)
+		}
+		token := rr.Body.String()
+		if token != cookies[0].Value {
+			t.Fatalf("expected context token to match cookie")
+		}
+
+		post := func(path, header, form string) int {
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.AddCookie(cookies[0])
+			if header != "" {
+				req.Header.Set("X-CSRF-Token", header)
+			}
+			rr := httptest.NewRecorder()
+			app.Mux.ServeHTTP(rr, req)
+			return rr.Code
+		}
+
+		if code := post("/submit", token, ""); code != http.StatusOK {
+			t.Fatalf("expected header token to be accepted, got %d", code)
+		}
+		if code := post("/submit", "", url.Values{"csrf_token": {token}}.Encode()); code != http.StatusOK {
+			t.Fatalf("expected form token to be accepted, got %d", code)
+		}
+		if code := post("/submit", "forged", ""); code != http.StatusForbidden {
+			t.Fatalf("expected forged token to be rejected, got %d", code)
+		}
+		if code := post("/submit", "", ""); code != http.StatusForbidden {
+			t.Fatalf("expected missing token to be rejected, got %d", code)
+		}
+		if code := post("/hook", "", ""); code != http.StatusOK {
+			t.Fatalf("expected exempt route to be accepted, got %d", code)
+		}
+	})
+
+	t.Run("synchronizer", func(t *testing.T) {
+		app := New()
+		app.AddGlobalMiddleware(CSRF(CSRFConfig{
+			Mode:      CSRFSynchronizer,
+			Secret:    []byte("secret"),
+			SessionID: func(r *http.Request) string { return r.Header.Get("X-Session") },
+		}))
+		app.AddEndpoint(http.MethodGet, "/form", ok)
+		app.AddEndpoint(http.MethodPost, "/submit", ok)
+
+		call := func(method, path, session, token string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, nil)
+			req.Header.Set("X-Session", session)
+			req.Header.Set("X-CSRF-Token", token)
+			rr := httptest.NewRecorder()
+			app.Mux.ServeHTTP(rr, req)
+			return rr
+		}
+
+		token := call(http.MethodGet, "/form", "alice", "").Body.String()
+		if token == "" {
+			t.Fatalf("expected a token for the session")
+		}
+		if rr := call(http.MethodPost, "/submit", "alice", token); rr.Code != http.StatusOK {
+			t.Fatalf("expected token to be accepted, got %d", rr.Code)
+		}
+		if rr := call(http.MethodPost, "/submit", "mallory", token); rr.Code != http.StatusForbidden {
+			t.Fatalf("expected token from another session to be rejected, got %d", rr.Code)
+		}
+	})
+
+	t.Run("fetch metadata", func(t *testing.T) {
+		app := New()
+		app.AddGlobalMiddleware(CSRF(CSRFConfig{
+			Mode:           CSRFFetchMetadata,
+			TrustedOrigins: []string{"https://*.example.com"},
+		}))
+		app.AddEndpoint(http.MethodPost, "/submit", ok)
+
+		cases := []struct {
+			name, site, origin string
+			want               int
+		}{
+			{"same origin", "same-origin", "https://app.test", http.StatusOK},
+			{"user initiated", "none", "", http.StatusOK},
+			{"cross site", "cross-site", "https://evil.test", http.StatusForbidden},
+			{"trusted cross site", "same-site", "https://admin.example.com", http.StatusOK},
+			{"legacy same origin", "", "http://app.test", http.StatusOK},
+			{"legacy cross origin", "", "https://evil.test", http.StatusForbidden},
+			{"non-browser client", "", "", http.StatusOK},
+		}
+		for _, tc := range cases {
+			req := httptest.NewRequest(http.MethodPost, "http://app.test/submit", nil)
+			if tc.site != "" {
+				req.Header.Set("Sec-Fetch-Site", tc.site)
+			}
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			rr := httptest.NewRecorder()
+			app.Mux.ServeHTTP(rr, req)
+			if rr.Code != tc.want {
+				t.Errorf("%s: expected status %d, got %d", tc.name, tc.want, rr.Code)
+			}
+		}
+	})
+}
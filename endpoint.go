@@ -18,11 +18,14 @@ type endpoint struct {
 	EndpointHandler http.HandlerFunc
 	// MiddlewareHandlers are the middleware functions specific to this endpoint
 	MiddlewareHandlers []MiddleWare
+	// options are the per-route settings attached with With
+	options *routeOptions
 }
 
 // NewEndpoint creates a new endpoint with the specified HTTP method, path, handler, and optional middleware.
 // This is the general constructor function for creating endpoints. For convenience,
-// method-specific constructors (GET, POST, etc.) are also provided.
+// method-specific constructors (GET, POST, etc.) are also provided. Per-route
+// options such as timeouts are attached to the result with With.
 //
 // Parameters:
 //   - method: The HTTP method (GET, POST, PUT, etc.)
@@ -41,6 +44,21 @@ func NewEndpoint(method, path string, endpointHandler http.HandlerFunc, mid ...M
 	}
 }
 
+// With returns a copy of the endpoint with the given per-route options applied.
+//
+// Parameters:
+//   - opts: The options to apply, in order
+//
+// Returns:
+//   - The endpoint with the options attached
+func (e endpoint) With(opts ...EndpointOption) endpoint {
+	e.options = e.options.clone()
+	for _, opt := range opts {
+		opt(e.options)
+	}
+	return e
+}
+
 // GET creates a new endpoint for handling HTTP GET requests at the specified path.
 // This is a convenience function that calls NewEndpoint with http.MethodGet as the method.
 //
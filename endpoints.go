@@ -45,3 +45,23 @@ func (e Endpoints) Prepend(mid ...MiddleWare) {
 		e[i].MiddlewareHandlers = append(mw, e[i].MiddlewareHandlers...)
 	}
 }
+
+// With applies per-route options to every endpoint in the collection.
+// Options are applied after any options already set on each endpoint.
+//
+// Parameters:
+//   - opts: A variadic list of options to apply to all endpoints
+func (e Endpoints) With(opts ...EndpointOption) {
+	for i := range e {
+		e[i] = e[i].With(opts...)
+	}
+}
+
+// Require restricts every endpoint in the collection to principals holding
+// all of the given scopes. It is shorthand for With(WithScopes(scopes...)).
+//
+// Parameters:
+//   - scopes: A variadic list of scopes required by all endpoints
+func (e Endpoints) Require(scopes ...string) {
+	e.With(WithScopes(scopes...))
+}
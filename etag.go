@@ -0,0 +1,209 @@
+// Package intake provides HTTP routing utilities.
+// This file contains middleware and helpers for entity tags and conditional
+// requests as defined in RFC 9110 section 13.
+package intake
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETagConfig defines the configuration options for the ETag middleware.
+type ETagConfig struct {
+	// Weak marks generated entity tags as weak (W/"..."). Use it when the
+	// response body is only semantically equivalent between requests, for
+	// example when it is compressed or contains formatting differences.
+	Weak bool
+}
+
+// ETag returns a middleware that adds entity tags to GET and HEAD responses
+// and answers conditional requests.
+//
+// If the handler of a GET request does not set an ETag header (see SetETag),
+// one is computed from a hash of the response body. HEAD responses only get
+// the ETag their handler sets, since their body is often empty and would
+// hash differently from the GET response. The response is then checked against
+// If-None-Match, If-Modified-Since, If-Match and If-Unmodified-Since, and a
+// 304 Not Modified or 412 Precondition Failed is sent instead of the body
+// when appropriate. Other methods pass through unchanged; handlers for
+// PUT, PATCH and DELETE should call Precondition before making changes.
+//
+// Every GET and HEAD response is buffered in full before it is sent, and
+// the handler's ResponseWriter does not implement http.Flusher, so
+// streaming routes should not use this middleware.
+//
+// Parameters:
+//   - config: The ETagConfig struct containing the ETag configuration
+//
+// Returns:
+//   - A MiddleWare function that can be applied to HTTP handlers
+func ETag(config ETagConfig) MiddleWare {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next(w, r)
+				return
+			}
+
+			buf := newResponseBuffer()
+			next(buf, r)
+
+			if buf.status() != http.StatusOK {
+				buf.writeTo(w)
+				return
+			}
+
+			etag := buf.Header().Get("ETag")
+			if etag == "" && r.Method == http.MethodHead {
+				buf.writeTo(w)
+				return
+			}
+			if etag == "" {
+				sum := sha256.Sum256(buf.body.Bytes())
+				etag = FormatETag(hex.EncodeToString(sum[:16]), config.Weak)
+				buf.Header().Set("ETag", etag)
+			}
+			lastModified, _ := http.ParseTime(buf.Header().Get("Last-Modified"))
+
+			switch code := evaluatePreconditions(r, etag, lastModified); code {
+			case http.StatusNotModified:
+				writeNotModified(w, buf.Header())
+			case http.StatusPreconditionFailed:
+				http.Error(w, http.StatusText(code), code)
+			default:
+				buf.writeTo(w)
+			}
+		}
+	}
+}
+
+// Precondition evaluates the conditional headers of r against the current
+// version of the target resource, identified by its entity tag and
+// modification time. Pass an empty etag when the resource does not exist and
+// a zero time when the modification time is unknown.
+//
+// If the request may proceed Precondition returns true and writes nothing.
+// Otherwise it writes 412 Precondition Failed (or 304 Not Modified for GET
+// and HEAD) and returns false, and the handler should return immediately.
+//
+// Parameters:
+//   - w: The HTTP response writer to write a failure response to
+//   - r: The HTTP request carrying the conditional headers
+//   - etag: The current entity tag, as produced by FormatETag or SetETag
+//   - lastModified: The current modification time of the resource
+//
+// Returns:
+//   - true if the request may proceed, false if a response was written
+func Precondition(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	switch code := evaluatePreconditions(r, etag, lastModified); code {
+	case http.StatusNotModified:
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		if !lastModified.IsZero() {
+			w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+		}
+		w.WriteHeader(code)
+		return false
+	case http.StatusPreconditionFailed:
+		http.Error(w, http.StatusText(code), code)
+		return false
+	}
+	return true
+}
+
+// FormatETag quotes tag as an entity tag, marking it weak if requested.
+// Tags that are already quoted are returned unchanged.
+//
+// Parameters:
+//   - tag: The opaque version identifier
+//   - weak: Whether the entity tag is weak
+//
+// Returns:
+//   - The formatted entity tag, e.g. "abc" or W/"abc"
+func FormatETag(tag string, weak bool) string {
+	if strings.HasPrefix(tag, `"`) || strings.HasPrefix(tag, `W/"`) {
+		return tag
+	}
+	tag = `"` + tag + `"`
+	if weak {
+		tag = "W/" + tag
+	}
+	return tag
+}
+
+// evaluatePreconditions applies the precedence rules of RFC 9110 section 13.2.2
+// and returns 0 if the request may proceed, or the status code to respond with.
+func evaluatePreconditions(r *http.Request, etag string, lastModified time.Time) int {
+	safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !etagListMatches(ifMatch, etag, true) {
+			return http.StatusPreconditionFailed
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && !lastModified.IsZero() {
+		if lastModified.Truncate(time.Second).After(since) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagListMatches(ifNoneMatch, etag, false) {
+			if safe {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if safe && !lastModified.IsZero() {
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+			if !lastModified.Truncate(time.Second).After(since) {
+				return http.StatusNotModified
+			}
+		}
+	}
+	return 0
+}
+
+// etagListMatches reports whether etag matches any entry of a comma separated
+// If-Match or If-None-Match list, using strong or weak comparison.
+func etagListMatches(list, etag string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strong {
+			if candidate == etag {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNotModified sends a 304 response carrying the validator and caching
+// headers from h, but no content headers or body.
+func writeNotModified(w http.ResponseWriter, h http.Header) {
+	dst := w.Header()
+	for _, key := range []string{"Cache-Control", "Content-Location", "Date", "ETag", "Expires", "Last-Modified", "Vary"} {
+		if values, ok := h[key]; ok {
+			dst[key] = values
+		}
+	}
+	dst.Del("Content-Type")
+	dst.Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+}
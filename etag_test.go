@@ -0,0 +1,111 @@
+package intake
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestETag(t *testing.T) {
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	app := New()
+	app.AddGlobalMiddleware(ETag(ETagConfig{}))
+	app.AddEndpoint(http.MethodGet, "/hashed", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	app.AddEndpoint(http.MethodGet, "/versioned", func(w http.ResponseWriter, r *http.Request) {
+		SetETag(w, "v2", true)
+		SetLastModified(w, modified)
+		w.Write([]byte("versioned"))
+	})
+
+	rr := httptest.NewRecorder()
+	app.Mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/hashed", nil))
+	etag := rr.Header().Get("ETag")
+	if etag == "" || etag[0] != '"' {
+		t.Fatalf("expected strong generated ETag, got %q", etag)
+	}
+
+	cases := []struct {
+		name   string
+		path   string
+		header string
+		value  string
+		want   int
+	}{
+		{name: "matching If-None-Match", path: "/hashed", header: "If-None-Match", value: etag, want: http.StatusNotModified},
+		{name: "stale If-None-Match", path: "/hashed", header: "If-None-Match", value: `"old"`, want: http.StatusOK},
+		{name: "weak comparison", path: "/versioned", header: "If-None-Match", value: `"v1", "v2"`, want: http.StatusNotModified},
+		{name: "If-Modified-Since", path: "/versioned", header: "If-Modified-Since", value: modified.Format(http.TimeFormat), want: http.StatusNotModified},
+		{name: "modified since", path: "/versioned", header: "If-Modified-Since", value: modified.Add(-time.Hour).Format(http.TimeFormat), want: http.StatusOK},
+		{name: "If-Match strong comparison", path: "/versioned", header: "If-Match", value: `W/"v2"`, want: http.StatusPreconditionFailed},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Header.Set(tc.header, tc.value)
+			rr := httptest.NewRecorder()
+			app.Mux.ServeHTTP(rr, req)
+
+			if rr.Code != tc.want {
+				t.Fatalf("expected status %d, got %d", tc.want, rr.Code)
+			}
+			if tc.want == http.StatusNotModified && rr.Body.Len() != 0 {
+				t.Fatalf("expected empty 304 body, got %q", rr.Body.String())
+			}
+		})
+	}
+
+	t.Run("HEAD", func(t *testing.T) {
+		head := func(path string) *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+			app.Mux.ServeHTTP(rr, httptest.NewRequest(http.MethodHead, path, nil))
+			return rr
+		}
+		if got := head("/hashed").Header().Get("ETag"); got != "" {
+			t.Fatalf("expected no generated ETag on HEAD, got %q", got)
+		}
+		if got := head("/versioned").Header().Get("ETag"); got != `W/"v2"` {
+			t.Fatalf("expected the handler's ETag on HEAD, got %q", got)
+		}
+	})
+}
+
+func TestPrecondition(t *testing.T) {
+	current := FormatETag("v3", false)
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	cases := []struct {
+		name   string
+		method string
+		header string
+		value  string
+		etag   string
+		want   int
+	}{
+		{name: "If-Match current", method: http.MethodPut, header: "If-Match", value: current, etag: current, want: http.StatusOK},
+		{name: "If-Match stale", method: http.MethodPut, header: "If-Match", value: `"v2"`, etag: current, want: http.StatusPreconditionFailed},
+		{name: "If-Match any on missing resource", method: http.MethodDelete, header: "If-Match", value: "*", etag: "", want: http.StatusPreconditionFailed},
+		{name: "If-None-Match any on create", method: http.MethodPut, header: "If-None-Match", value: "*", etag: "", want: http.StatusOK},
+		{name: "If-None-Match any on existing", method: http.MethodPut, header: "If-None-Match", value: "*", etag: current, want: http.StatusPreconditionFailed},
+		{name: "If-Unmodified-Since stale", method: http.MethodPatch, header: "If-Unmodified-Since", value: modified.Add(-time.Hour).Format(http.TimeFormat), etag: current, want: http.StatusPreconditionFailed},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/items/1", nil)
+			req.Header.Set(tc.header, tc.value)
+			rr := httptest.NewRecorder()
+
+			if Precondition(rr, req, tc.etag, modified) {
+				rr.WriteHeader(http.StatusOK)
+			}
+			if rr.Code != tc.want {
+				t.Fatalf("expected status %d, got %d", tc.want, rr.Code)
+			}
+		})
+	}
+}
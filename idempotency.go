@@ -0,0 +1,288 @@
+// Package intake provides HTTP routing utilities.
+// This file contains middleware implementing the Idempotency-Key HTTP header
+// for unsafe methods, and the storage interface it uses.
+package intake
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// IdempotencyRecord is the stored state of a request made with an
+// idempotency key.
+type IdempotencyRecord struct {
+	// Fingerprint identifies the request payload the key was first used with.
+	Fingerprint string
+	// Completed reports whether the response below has been stored. A record
+	// that is not completed belongs to a request that is still in flight.
+	Completed bool
+
+	// Status, Header and Body describe the stored response.
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// IdempotencyStore stores idempotency records. Implementations must be safe
+// for concurrent use and must make Reserve atomic.
+type IdempotencyStore interface {
+	// Reserve claims key for a new request with the given fingerprint. If
+	// the key is already known its record is returned with acquired false.
+	Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (record *IdempotencyRecord, acquired bool, err error)
+	// Complete stores the response for a key previously reserved.
+	Complete(ctx context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error
+	// Release drops a reservation without storing a response so the request
+	// can be retried.
+	Release(ctx context.Context, key string) error
+}
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore whose records
+// expire after their TTL.
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]memoryIdempotencyItem
+	sweep   time.Time
+}
+
+type memoryIdempotencyItem struct {
+	record  *IdempotencyRecord
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore creates an empty MemoryIdempotencyStore.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{records: make(map[string]memoryIdempotencyItem)}
+}
+
+// Reserve claims key unless an unexpired record already exists for it.
+func (s *MemoryIdempotencyStore) Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.After(s.sweep) {
+		for k, item := range s.records {
+			if now.After(item.expires) {
+				delete(s.records, k)
+			}
+		}
+		s.sweep = now.Add(time.Minute)
+	}
+
+	if item, ok := s.records[key]; ok && now.Before(item.expires) {
+		record := *item.record
+		return &record, false, nil
+	}
+	s.records[key] = memoryIdempotencyItem{
+		record:  &IdempotencyRecord{Fingerprint: fingerprint},
+		expires: now.Add(ttl),
+	}
+	return nil, true, nil
+}
+
+// Complete stores record under key for ttl.
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key] = memoryIdempotencyItem{record: record, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+// Release removes key.
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+// IdempotencyConfig defines the configuration options for the Idempotency middleware.
+type IdempotencyConfig struct {
+	// Store holds idempotency records. Default value is a MemoryIdempotencyStore.
+	Store IdempotencyStore
+
+	// Header is the request header carrying the key. Default value is "Idempotency-Key".
+	Header string
+
+	// TTL is how long a stored response is replayed for. Default is 24 hours.
+	TTL time.Duration
+
+	// Required rejects requests without a key with 400 Bad Request instead
+	// of processing them normally.
+	Required bool
+
+	// Principal returns the identity a key belongs to, so different clients
+	// can use the same key independently. If nil, keys are global.
+	Principal func(r *http.Request) string
+
+	// MaxBodyBytes is the largest request body that is read to fingerprint
+	// the payload. Larger bodies are rejected with 413. Default is 1 MiB.
+	MaxBodyBytes int64
+
+	// Logger receives store failures at Error level. Default is slog.Default().
+	Logger *slog.Logger
+}
+
+// DefaultIdempotencyConfig returns an idempotency configuration with common settings.
+// The default configuration:
+// - Stores records in memory for 24 hours
+// - Reads the key from the Idempotency-Key header
+// - Processes requests without a key normally
+func DefaultIdempotencyConfig() IdempotencyConfig {
+	return IdempotencyConfig{
+		Store:        NewMemoryIdempotencyStore(),
+		Header:       "Idempotency-Key",
+		TTL:          24 * time.Hour,
+		MaxBodyBytes: 1 << 20,
+	}
+}
+
+// Idempotency returns a middleware that makes unsafe requests carrying an
+// Idempotency-Key header safe to retry.
+//
+// The first response for a key and principal is stored and replayed for any
+// retry with the same payload, marked with an Idempotent-Replayed header. A
+// retry that arrives while the first request is still being processed gets
+// 409 Conflict, and reusing a key with a different payload gets 422
+// Unprocessable Entity. Server errors are not stored, so the client may retry
+// them. Apply it to the routes that need it with Endpoints.Use.
+//
+// Store failures are logged. If a key cannot be reserved the request fails
+// with 500 Internal Server Error; if a response cannot be stored, it is
+// still sent and the key stays reserved until its TTL expires.
+//
+// Parameters:
+//   - config: The IdempotencyConfig struct containing the idempotency configuration
+//
+// Returns:
+//   - A MiddleWare function that can be applied to HTTP handlers
+func Idempotency(config IdempotencyConfig) MiddleWare {
+	defaults := DefaultIdempotencyConfig()
+	if config.Store == nil {
+		config.Store = defaults.Store
+	}
+	if config.Header == "" {
+		config.Header = defaults.Header
+	}
+	if config.TTL <= 0 {
+		config.TTL = defaults.TTL
+	}
+	if config.MaxBodyBytes <= 0 {
+		config.MaxBodyBytes = defaults.MaxBodyBytes
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	release := func(ctx context.Context, key string) {
+		if err := config.Store.Release(context.WithoutCancel(ctx), key); err != nil {
+			config.Logger.ErrorContext(ctx, "failed to release idempotency key", "error", err)
+		}
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+				next(w, r)
+				return
+			}
+
+			key := r.Header.Get(config.Header)
+			if key == "" {
+				if config.Required {
+					http.Error(w, "missing "+config.Header+" header", http.StatusBadRequest)
+					return
+				}
+				next(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, config.MaxBodyBytes+1))
+			if err != nil {
+				http.Error(w, "failed to read request body", http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > config.MaxBodyBytes {
+				respondTooLarge(w)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			fingerprint := idempotencyFingerprint(r, body)
+			if config.Principal != nil {
+				key = config.Principal(r) + "\x00" + key
+			}
+
+			ctx := r.Context()
+			record, acquired, err := config.Store.Reserve(ctx, key, fingerprint, config.TTL)
+			if err != nil {
+				config.Logger.ErrorContext(ctx, "failed to reserve idempotency key", "error", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if !acquired {
+				switch {
+				case record.Fingerprint != fingerprint:
+					http.Error(w, config.Header+" was used with a different request payload", http.StatusUnprocessableEntity)
+				case !record.Completed:
+					http.Error(w, "a request with this "+config.Header+" is already being processed", http.StatusConflict)
+				default:
+					dst := w.Header()
+					for k, values := range record.Header {
+						dst[k] = slices.Clone(values)
+					}
+					dst.Set("Idempotent-Replayed", "true")
+					w.WriteHeader(record.Status)
+					w.Write(record.Body)
+				}
+				return
+			}
+
+			buf := newResponseBuffer()
+			completed := false
+			defer func() {
+				if !completed {
+					// The handler panicked; let the client retry.
+					release(ctx, key)
+				}
+			}()
+			next(buf, r)
+			completed = true
+
+			if buf.status() >= http.StatusInternalServerError {
+				release(ctx, key)
+			} else if err := config.Store.Complete(context.WithoutCancel(ctx), key, &IdempotencyRecord{
+				Fingerprint: fingerprint,
+				Completed:   true,
+				Status:      buf.status(),
+				Header:      buf.Header().Clone(),
+				Body:        slices.Clone(buf.body.Bytes()),
+			}, config.TTL); err != nil {
+				// The handler has run, so the reservation is kept: retries
+				// get 409 Conflict rather than running it a second time.
+				config.Logger.ErrorContext(ctx, "failed to store idempotent response", "error", err)
+			}
+			buf.writeTo(w)
+		}
+	}
+}
+
+// idempotencyFingerprint hashes the parts of a request that must match for
+// a retry to be considered the same request.
+func idempotencyFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method)
+	h.Write([]byte{0})
+	io.WriteString(h, r.URL.RequestURI())
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
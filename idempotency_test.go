@@ -0,0 +1,139 @@
+package intake
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotency(t *testing.T) {
+	var orders atomic.Int32
+	inFlight := make(chan struct{})
+	release := make(chan struct{})
+
+	orderEndpoints := Endpoints{
+		POST("/orders", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Block") != "" {
+				close(inFlight)
+				<-release
+			}
+			n := orders.Add(1)
+			w.Header().Set("Location", fmt.Sprintf("/orders/%d", n))
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, "order %d", n)
+		}),
+	}
+	orderEndpoints.Use(Idempotency(IdempotencyConfig{
+		Principal: func(r *http.Request) string { return r.Header.Get("X-User") },
+	}))
+
+	app := New()
+	app.AddEndpoints(orderEndpoints)
+
+	post := func(key, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		req.Header.Set("X-User", user)
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := post("key-1", "alice", `{"item":1}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, first.Code)
+	}
+
+	t.Run("replays the stored response", func(t *testing.T) {
+		rr := post("key-1", "alice", `{"item":1}`)
+		if rr.Code != http.StatusCreated || rr.Body.String() != first.Body.String() {
+			t.Fatalf("expected replayed response %q, got %d %q", first.Body.String(), rr.Code, rr.Body.String())
+		}
+		if got := rr.Header().Get("Idempotent-Replayed"); got != "true" {
+			t.Fatalf("expected Idempotent-Replayed header, got %q", got)
+		}
+		if got := orders.Load(); got != 1 {
+			t.Fatalf("expected 1 order, got %d", got)
+		}
+	})
+
+	t.Run("rejects a different payload", func(t *testing.T) {
+		if rr := post("key-1", "alice", `{"item":2}`); rr.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("expected status %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+		}
+	})
+
+	t.Run("keys are scoped to the principal", func(t *testing.T) {
+		if rr := post("key-1", "bob", `{"item":1}`); rr.Header().Get("Idempotent-Replayed") != "" {
+			t.Fatalf("expected a new order for a different principal")
+		}
+	})
+
+	t.Run("rejects concurrent duplicates", func(t *testing.T) {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("{}"))
+			req.Header.Set("Idempotency-Key", "key-2")
+			req.Header.Set("X-Block", "1")
+			app.Mux.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+		<-inFlight
+
+		if rr := post("key-2", "", "{}"); rr.Code != http.StatusConflict {
+			t.Fatalf("expected status %d, got %d", http.StatusConflict, rr.Code)
+		}
+		close(release)
+		<-done
+	})
+}
+
+// failingIdempotencyStore reserves keys in memory but cannot store responses.
+type failingIdempotencyStore struct {
+	*MemoryIdempotencyStore
+}
+
+func (s failingIdempotencyStore) Complete(ctx context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error {
+	return errors.New("store unavailable")
+}
+
+func TestIdempotencyStoreFailure(t *testing.T) {
+	var logs bytes.Buffer
+	var executions atomic.Int32
+	handler := Idempotency(IdempotencyConfig{
+		Store:  failingIdempotencyStore{NewMemoryIdempotencyStore()},
+		Logger: slog.New(slog.NewTextHandler(&logs, nil)),
+	})(func(w http.ResponseWriter, r *http.Request) {
+		executions.Add(1)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	call := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"item":1}`))
+		req.Header.Set("Idempotency-Key", "order-1")
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	if rr := call(); rr.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, rr.Code)
+	}
+	if !strings.Contains(logs.String(), "store unavailable") {
+		t.Fatalf("expected the store failure to be logged, got %q", logs.String())
+	}
+	if rr := call(); rr.Code != http.StatusConflict {
+		t.Fatalf("expected retry to get %d, got %d", http.StatusConflict, rr.Code)
+	}
+	if got := executions.Load(); got != 1 {
+		t.Fatalf("expected the handler to run once, ran %d times", got)
+	}
+}
@@ -6,10 +6,14 @@ package intake
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -33,6 +37,15 @@ type Intake struct {
 	GlobalMiddleware []MiddleWare
 	// registeredRoutes maps paths to their HTTP methods
 	registeredRoutes map[string][]string
+	// routeOptions maps "METHOD path" keys to the options of each route
+	routeOptions map[string]*routeOptions
+	// optionsConfig is set once AddOptionsEndpoints has been called
+	optionsConfig *OptionsConfig
+	// autoOptions holds the handlers of OPTIONS endpoints added by
+	// AddOptionsEndpoints, so that explicit ones can replace them
+	autoOptions map[string]*atomic.Pointer[http.HandlerFunc]
+	// mu guards the route registry, which is read while serving requests
+	mu sync.RWMutex
 }
 
 // New creates a new Intake instance with initialized maps and slices.
@@ -44,6 +57,7 @@ func New() *Intake {
 		GlobalMiddleware: make([]MiddleWare, 0),
 		Mux:              http.NewServeMux(),
 		registeredRoutes: make(map[string][]string),
+		routeOptions:     make(map[string]*routeOptions),
 	}
 }
 
@@ -80,7 +94,7 @@ func (a *Intake) AddGlobalMiddleware(mw MiddleWare) {
 func (a *Intake) AddEndpoints(e ...Endpoints) {
 	for x := range e {
 		for i := range e[x] {
-			a.AddEndpoint(e[x][i].Verb, e[x][i].Path, e[x][i].EndpointHandler, e[x][i].MiddlewareHandlers...)
+			a.addEndpoint(e[x][i])
 		}
 	}
 }
@@ -97,23 +111,74 @@ func (a *Intake) AddEndpoints(e ...Endpoints) {
 //   - finalHandler: The handler function that will process the request
 //   - middleware: Optional route-specific middleware functions
 func (a *Intake) AddEndpoint(verb string, path string, finalHandler http.HandlerFunc, middleware ...MiddleWare) {
-	// Store the route in our registry
-	if methods, exists := a.registeredRoutes[path]; exists {
-		a.registeredRoutes[path] = append(methods, verb)
+	a.addEndpoint(NewEndpoint(verb, path, finalHandler, middleware...))
+}
+
+// addEndpoint registers a single endpoint, including any per-route options.
+func (a *Intake) addEndpoint(e endpoint) {
+	verb, path := e.Verb, e.Path
+	handlerKey := fmt.Sprintf("%s %s", verb, path)
+
+	// Store the route in our registry. An explicit OPTIONS endpoint replaces
+	// the one added by AddOptionsEndpoints instead of conflicting with it.
+	a.mu.Lock()
+	replace := a.autoOptions[path]
+	if verb == http.MethodOptions && replace != nil {
+		delete(a.autoOptions, path)
+	} else {
+		replace = nil
+		a.registeredRoutes[path] = append(a.registeredRoutes[path], verb)
+	}
+	if e.options != nil {
+		if a.routeOptions == nil {
+			a.routeOptions = make(map[string]*routeOptions)
+		}
+		a.routeOptions[handlerKey] = e.options
+	}
+	// Once AddOptionsEndpoints has been called, new paths get an OPTIONS
+	// endpoint as they are registered.
+	var auto *atomic.Pointer[http.HandlerFunc]
+	if a.optionsConfig != nil && !slices.Contains(a.registeredRoutes[path], http.MethodOptions) {
+		auto = a.reserveOptions(path)
+	}
+	a.mu.Unlock()
+
+	handler := a.buildHandler(e)
+	if replace != nil {
+		replace.Store(&handler)
 	} else {
-		a.registeredRoutes[path] = []string{verb}
+		a.Mux.HandleFunc(handlerKey, handler)
+	}
+	if auto != nil {
+		a.registerOptions(path, auto)
 	}
+}
 
-	handlerKey := fmt.Sprintf("%s %s", verb, path)
+// buildHandler wraps the endpoint's handler in its middleware, the global
+// middleware and panic recovery.
+func (a *Intake) buildHandler(e endpoint) http.HandlerFunc {
+	verb, path, middleware := e.Verb, e.Path, e.MiddlewareHandlers
 
-	// Build route-specific chain first.
-	routeHandler := finalHandler
+	// Build route-specific chain first. Authorization runs innermost so the
+	// principal set by any authentication middleware is visible to it.
+	routeHandler := e.EndpointHandler
+	if e.options.requiresAuthorization() {
+		routeHandler = authorizeRoute(e.options)(routeHandler)
+	}
 	for i := len(middleware) - 1; i >= 0; i-- {
 		if middleware[i] != nil {
 			routeHandler = middleware[i](routeHandler)
 		}
 	}
 
+	// Per-route options that change how the route runs wrap the route chain.
+	if e.options != nil && e.options.bodyLimit != nil {
+		routeHandler = bodyLimitHandler(routeHandler, *e.options.bodyLimit)
+	}
+	if e.options != nil && e.options.timeout > 0 {
+		routeHandler = timeoutHandler(routeHandler, e.options.timeout)
+	}
+
 	// Apply global middleware in reverse order
 	handler := routeHandler
 	for i := len(a.GlobalMiddleware) - 1; i >= 0; i-- {
@@ -122,6 +187,21 @@ func (a *Intake) AddEndpoint(verb string, path string, finalHandler http.Handler
 		}
 	}
 
+	// Per-route CORS policies run ahead of the global middleware, so
+	// preflights are answered before any authentication. Preflights are
+	// routed to the OPTIONS endpoint, so that one resolves the policy of the
+	// route the browser is asking about.
+	if e.options != nil && e.options.cors != nil {
+		handler = e.options.cors.Middleware(handler)
+	} else if verb == http.MethodOptions {
+		handler = a.preflightCORS(path, handler)
+	}
+
+	// Expose the route options to global middleware.
+	if e.options != nil {
+		handler = withRouteOptions(e.options)(handler)
+	}
+
 	// Apply panic recovery last so it wraps global and route middleware.
 	if a.PanicHandler != nil {
 		inner := handler
@@ -135,7 +215,35 @@ func (a *Intake) AddEndpoint(verb string, path string, finalHandler http.Handler
 		}
 	}
 
-	a.Mux.HandleFunc(handlerKey, handler)
+	return handler
+}
+
+// preflightCORS returns a handler that answers preflights for path with the
+// CORS policy of the route named by Access-Control-Request-Method, if that
+// route has one, and otherwise calls next unchanged. HEAD preflights use the
+// GET route unless a HEAD route is registered for path.
+func (a *Intake) preflightCORS(path string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		method := r.Header.Get("Access-Control-Request-Method")
+		a.mu.RLock()
+		target := a.routeOptions[method+" "+path]
+		if method == http.MethodHead && !slices.Contains(a.registeredRoutes[path], http.MethodHead) {
+			// http.ServeMux serves HEAD through the GET route.
+			target = a.routeOptions[http.MethodGet+" "+path]
+		}
+		a.mu.RUnlock()
+		if method == "" || target == nil || target.cors == nil {
+			next(w, r)
+			return
+		}
+
+		// Record the policy in the route options so the global CORS
+		// middleware steps aside.
+		opts := routeOptionsFrom(r).clone()
+		opts.cors = target.cors
+		r = r.WithContext(context.WithValue(r.Context(), routeOptionsKey{}, opts))
+		target.cors.Middleware(next)(w, r)
+	}
 }
 
 // Run starts the HTTP server and handles graceful shutdown on SIGINT/SIGTERM.
@@ -147,11 +255,31 @@ func (a *Intake) AddEndpoint(verb string, path string, finalHandler http.Handler
 // Parameters:
 //   - server: The configured http.Server instance to run
 func (a *Intake) Run(server *http.Server) {
+	a.run(server, server.ListenAndServe)
+}
+
+// RunListener is like Run but serves connections accepted by l, such as a
+// ProxyListener. When l is a *ProxyListener and server.ConnContext is not
+// set, ProxyConnContext is installed so handlers can read PROXY headers.
+//
+// Parameters:
+//   - server: The configured http.Server instance to run
+//   - l: The listener to accept connections from
+func (a *Intake) RunListener(server *http.Server, l net.Listener) {
+	if _, ok := l.(*ProxyListener); ok && server.ConnContext == nil {
+		server.ConnContext = ProxyConnContext
+	}
+	a.run(server, func() error { return server.Serve(l) })
+}
+
+// run calls serve and blocks until it fails or a termination signal arrives,
+// in which case the server is shut down gracefully.
+func (a *Intake) run(server *http.Server, serve func() error) {
 	serverErrors := make(chan error, 1)
 	osSignals := make(chan os.Signal, 1)
 	signal.Notify(osSignals, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
-		serverErrors <- server.ListenAndServe()
+		serverErrors <- serve()
 	}()
 
 	// Blocking main and waiting for shutdown.
@@ -176,6 +304,8 @@ func (a *Intake) Run(server *http.Server) {
 //   - A map where keys are URL paths and values are slices of HTTP methods
 //     supported by each path.
 func (a *Intake) GetRoutes() map[string][]string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
 	routes := make(map[string][]string)
 	for path, methods := range a.registeredRoutes {
 		routes[path] = slices.Clone(methods)
@@ -183,25 +313,80 @@ func (a *Intake) GetRoutes() map[string][]string {
 	return routes
 }
 
+// RouteInfo describes a registered route and its access requirements.
+type RouteInfo struct {
+	// Method is the HTTP method of the route
+	Method string
+	// Path is the URL pattern of the route
+	Path string
+	// Scopes are the scopes a principal must hold to call the route
+	Scopes []string
+	// Roles are the roles of which a principal must hold at least one
+	Roles []string
+}
+
+// Routes returns every registered route with its scope and role
+// requirements, sorted by path and method. Routes without requirements have
+// empty Scopes and Roles, which makes it easy to audit which endpoints are
+// left open.
+//
+// Returns:
+//   - A slice of RouteInfo, one per registered method and path
+func (a *Intake) Routes() []RouteInfo {
+	a.mu.RLock()
+	var routes []RouteInfo
+	for path, methods := range a.registeredRoutes {
+		for _, method := range methods {
+			info := RouteInfo{Method: method, Path: path}
+			if opts := a.routeOptions[method+" "+path]; opts != nil {
+				info.Scopes = slices.Clone(opts.scopes)
+				info.Roles = slices.Clone(opts.roles)
+			}
+			routes = append(routes, info)
+		}
+	}
+	a.mu.RUnlock()
+	slices.SortFunc(routes, func(x, y RouteInfo) int {
+		if c := strings.Compare(x.Path, y.Path); c != 0 {
+			return c
+		}
+		return strings.Compare(x.Method, y.Method)
+	})
+	return routes
+}
+
 // AddOptionsEndpoints automatically adds HTTP OPTIONS handlers for all registered routes.
 // This is particularly useful for CORS preflight requests. The generated OPTIONS handlers
 // will respond with a 204 No Content status, and the actual CORS headers will be set by
-// any CORS middleware that has been applied.
+// any CORS middleware that has been applied. Preflights for a route with its own policy,
+// set with WithCORS, are answered with that policy instead of the global one.
+//
+// Each response carries an Allow header listing the methods registered for the path,
+// including HEAD for paths with GET. Routes registered after this call get OPTIONS
+// handlers too, and an OPTIONS endpoint registered explicitly replaces the generated one.
 //
-// This method should be called after all other routes have been registered.
-func (a *Intake) AddOptionsEndpoints() {
+// Parameters:
+//   - config: Optional OptionsConfig, e.g. to describe resources for API discovery
+func (a *Intake) AddOptionsEndpoints(config ...OptionsConfig) {
+	var paths []string
+	var reserved []*atomic.Pointer[http.HandlerFunc]
+
+	a.mu.Lock()
+	a.optionsConfig = &OptionsConfig{}
+	if len(config) > 0 {
+		a.optionsConfig = &config[0]
+	}
 	for path, methods := range a.registeredRoutes {
 		// Skip if OPTIONS is already registered for this path
 		if slices.Contains(methods, http.MethodOptions) {
 			continue
 		}
+		paths = append(paths, path)
+		reserved = append(reserved, a.reserveOptions(path))
+	}
+	a.mu.Unlock()
 
-		// Create an OPTIONS handler that just returns 204 No Content
-		optionsHandler := func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(http.StatusNoContent)
-		}
-
-		// Register the OPTIONS handler for this path
-		a.AddEndpoint(http.MethodOptions, path, optionsHandler)
+	for i, path := range paths {
+		a.registerOptions(path, reserved[i])
 	}
 }
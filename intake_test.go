@@ -342,9 +342,12 @@ func TestIntake(t *testing.T) {
 }
 
 func TestIsOriginAllowedWildcardSubdomain(t *testing.T) {
-	policy := buildPolicy(CORSConfig{
+	policy, err := buildPolicy(CORSConfig{
 		AllowedOrigins: []string{"https://*.example.com"},
 	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
 	cases := []struct {
 		origin string
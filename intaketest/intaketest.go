@@ -0,0 +1,41 @@
+// Package intaketest provides helpers for testing intake configurations,
+// so that mistakes are caught in CI rather than at startup.
+package intaketest
+
+import (
+	"testing"
+
+	"github.com/dbubel/intake/v2"
+)
+
+// CheckCORS fails the test if config is invalid, reporting every problem
+// found by config.Validate. Warnings about risky settings are logged but do
+// not fail the test; use StrictCORS for that.
+//
+// Parameters:
+//   - t: The test or benchmark to report to
+//   - config: The CORS configuration to check
+func CheckCORS(t testing.TB, config intake.CORSConfig) {
+	t.Helper()
+	if err := config.Validate(); err != nil {
+		t.Errorf("invalid CORS configuration:\n%v", err)
+	}
+	for _, warning := range config.Warnings() {
+		t.Logf("CORS warning: %s", warning)
+	}
+}
+
+// StrictCORS is like CheckCORS but also fails the test on warnings.
+//
+// Parameters:
+//   - t: The test or benchmark to report to
+//   - config: The CORS configuration to check
+func StrictCORS(t testing.TB, config intake.CORSConfig) {
+	t.Helper()
+	if err := config.Validate(); err != nil {
+		t.Errorf("invalid CORS configuration:\n%v", err)
+	}
+	for _, warning := range config.Warnings() {
+		t.Errorf("CORS warning: %s", warning)
+	}
+}
@@ -0,0 +1,49 @@
+package intaketest
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/dbubel/intake/v2"
+)
+
+// recorder captures failures instead of failing the enclosing test.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Logf(format string, args ...any) {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestCheckCORS(t *testing.T) {
+	CheckCORS(t, intake.DefaultCORSConfig())
+
+	risky := intake.CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{http.MethodGet},
+		AllowedHeaders:   []string{"*"},
+		AllowCredentials: true,
+	}
+	rec := &recorder{TB: t}
+	CheckCORS(rec, risky)
+	if len(rec.errors) != 0 {
+		t.Fatalf("expected warnings not to fail CheckCORS, got %v", rec.errors)
+	}
+	StrictCORS(rec, risky)
+	if len(rec.errors) != 1 {
+		t.Fatalf("expected StrictCORS to fail on the warning, got %v", rec.errors)
+	}
+
+	rec = &recorder{TB: t}
+	CheckCORS(rec, intake.CORSConfig{AllowedOrigins: []string{""}, AllowedMethods: []string{"get"}})
+	if len(rec.errors) != 1 {
+		t.Fatalf("expected one failure listing every problem, got %v", rec.errors)
+	}
+}
@@ -0,0 +1,327 @@
+// Package intake provides HTTP routing utilities.
+// This file contains middleware that allows or denies requests by client IP
+// address, using CIDR sets stored in a prefix trie.
+package intake
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ipTrieNode is a node of a binary trie keyed by address bits.
+type ipTrieNode struct {
+	children [2]*ipTrieNode
+	// terminal marks the end of an inserted prefix.
+	terminal bool
+}
+
+// ipTrie is a set of IP prefixes supporting lookups in time proportional to
+// the address length, however many prefixes it holds.
+type ipTrie struct {
+	v4, v6 ipTrieNode
+	size   int
+}
+
+func newIPTrie(prefixes prefixSet) *ipTrie {
+	t := &ipTrie{}
+	for _, prefix := range prefixes {
+		t.insert(prefix)
+	}
+	return t
+}
+
+func (t *ipTrie) root(addr netip.Addr) *ipTrieNode {
+	if addr.Is4() {
+		return &t.v4
+	}
+	return &t.v6
+}
+
+func (t *ipTrie) insert(prefix netip.Prefix) {
+	addr, bits := prefix.Addr(), prefix.Bits()
+	// Store IPv4-mapped prefixes in the IPv4 trie. A prefix shorter than
+	// /96 spans more than the mapped range, so it stays an IPv6 prefix.
+	if addr.Is4In6() && bits >= 96 {
+		addr, bits = addr.Unmap(), bits-96
+	}
+	raw := addr.AsSlice()
+	node := t.root(addr)
+	for i := 0; i < bits; i++ {
+		bit := raw[i/8] >> (7 - i%8) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &ipTrieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.terminal = true
+	t.size++
+}
+
+// contains reports whether addr is covered by any prefix in the trie.
+func (t *ipTrie) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	raw := addr.AsSlice()
+	node := t.root(addr)
+	for i := 0; ; i++ {
+		if node.terminal {
+			return true
+		}
+		if i == len(raw)*8 {
+			return false
+		}
+		node = node.children[raw[i/8]>>(7-i%8)&1]
+		if node == nil {
+			return false
+		}
+	}
+}
+
+// ipRules is an immutable pair of allow and deny sets.
+type ipRules struct {
+	allow, deny *ipTrie
+	// allowList reports whether an allow list was configured, even an
+	// empty one, in which case only addresses in it are allowed
+	allowList bool
+	// fileAllow reports whether the rules file provided allow rules
+	fileAllow bool
+}
+
+// allowed reports whether addr passes the rules: it must not be denied and,
+// if an allow list is configured, must be allowed.
+func (r *ipRules) allowed(addr netip.Addr) bool {
+	if !addr.IsValid() || r.deny.contains(addr) {
+		return false
+	}
+	return !r.allowList || r.allow.contains(addr)
+}
+
+// IPFilterConfig defines the configuration options for an IPFilter.
+type IPFilterConfig struct {
+	// Allow lists the IPv4 and IPv6 CIDRs or addresses that may make
+	// requests. If nil and File has no allow rules, every address not
+	// denied is allowed. A non-nil empty list denies every address.
+	Allow []string
+
+	// Deny lists CIDRs or addresses that may not make requests. Deny takes
+	// precedence over Allow.
+	Deny []string
+
+	// File, if set, is read for additional rules, one per line, such as
+	// "allow 10.8.0.0/16" or "deny 192.0.2.7". Blank lines and lines starting
+	// with # are ignored. The file is reloaded when it changes. Once the
+	// file has provided allow rules, a reload that yields none, e.g. from a
+	// truncated file, is rejected and the previous rules are kept.
+	File string
+
+	// ReloadInterval is how often File is checked for changes. Default is
+	// 10 seconds.
+	ReloadInterval time.Duration
+
+	// Logger receives a record for every decision: denials at Warn level and
+	// allowed requests at Debug level. Default is slog.Default().
+	Logger *slog.Logger
+}
+
+// IPFilter allows or denies requests by client IP, as resolved by ClientIP.
+// Its rules can be replaced at runtime without interrupting requests.
+type IPFilter struct {
+	logger *slog.Logger
+	rules  atomic.Pointer[ipRules]
+	// file and reloadInterval never change, so they are read without mu
+	file           string
+	reloadInterval time.Duration
+
+	// mu guards the fields below and serializes reloads
+	mu      sync.Mutex
+	config  IPFilterConfig
+	modTime time.Time
+	checked time.Time
+}
+
+// NewIPFilter creates an IPFilter from config, reading config.File if set.
+//
+// Parameters:
+//   - config: The IPFilterConfig struct containing the filter configuration
+//
+// Returns:
+//   - The filter, or an error if a rule is invalid or the file cannot be read
+func NewIPFilter(config IPFilterConfig) (*IPFilter, error) {
+	if config.ReloadInterval <= 0 {
+		config.ReloadInterval = 10 * time.Second
+	}
+	f := &IPFilter{
+		config:         config,
+		logger:         config.Logger,
+		file:           config.File,
+		reloadInterval: config.ReloadInterval,
+	}
+	if f.logger == nil {
+		f.logger = slog.Default()
+	}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// IPAccess returns a middleware that only lets through requests from
+// addresses allowed by config, answering others with 403 Forbidden. It is
+// shorthand for NewIPFilter(config).Middleware and panics if config is
+// invalid. Apply it to a group with Endpoints.Use to restrict only those
+// routes.
+//
+// Parameters:
+//   - config: The IPFilterConfig struct containing the filter configuration
+//
+// Returns:
+//   - A MiddleWare function that can be applied to HTTP handlers
+func IPAccess(config IPFilterConfig) MiddleWare {
+	f, err := NewIPFilter(config)
+	if err != nil {
+		panic("intake: IPAccess: " + err.Error())
+	}
+	return f.Middleware
+}
+
+// Reload rebuilds the rules from the configured lists and file. If the file
+// cannot be read or parsed, the current rules are kept.
+func (f *IPFilter) Reload() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reload()
+}
+
+// reload must be called with f.mu held.
+func (f *IPFilter) reload() error {
+	allow, deny := f.config.Allow, f.config.Deny
+	allowList := f.config.Allow != nil
+	var fileAllow []string
+	var modTime time.Time
+	if f.file != "" {
+		info, err := os.Stat(f.file)
+		if err != nil {
+			return err
+		}
+		var fileDeny []string
+		fileAllow, fileDeny, err = readIPRulesFile(f.file)
+		if err != nil {
+			return err
+		}
+		allow = append(append([]string{}, allow...), fileAllow...)
+		deny = append(append([]string{}, deny...), fileDeny...)
+		modTime = info.ModTime()
+	}
+
+	// A rules file that used to allow addresses and now allows none is more
+	// likely truncated or half-written than meant to open the filter up.
+	if previous := f.rules.Load(); previous != nil && f.file != "" && previous.fileAllow && len(fileAllow) == 0 {
+		return fmt.Errorf("%s: no allow rules left; keeping the previous rules", f.file)
+	}
+
+	allowSet, err := parsePrefixes(allow)
+	if err != nil {
+		return err
+	}
+	denySet, err := parsePrefixes(deny)
+	if err != nil {
+		return err
+	}
+	f.rules.Store(&ipRules{
+		allow:     newIPTrie(allowSet),
+		deny:      newIPTrie(denySet),
+		allowList: allowList || len(fileAllow) > 0,
+		fileAllow: len(fileAllow) > 0,
+	})
+	f.modTime = modTime
+	f.checked = time.Now()
+	return nil
+}
+
+// Update replaces the configured allow and deny lists and rebuilds the rules.
+func (f *IPFilter) Update(allow, deny []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	previous := f.config
+	f.config.Allow, f.config.Deny = allow, deny
+	if err := f.reload(); err != nil {
+		f.config = previous
+		return err
+	}
+	return nil
+}
+
+// Allowed reports whether addr passes the current rules.
+func (f *IPFilter) Allowed(addr netip.Addr) bool {
+	f.checkFile()
+	return f.rules.Load().allowed(addr)
+}
+
+// checkFile reloads the rules file if the reload interval has passed and
+// the file has changed since it was last read.
+func (f *IPFilter) checkFile() {
+	if f.file == "" || !f.mu.TryLock() {
+		return
+	}
+	defer f.mu.Unlock()
+	if time.Since(f.checked) < f.reloadInterval {
+		return
+	}
+	f.checked = time.Now()
+	info, err := os.Stat(f.file)
+	if err != nil || info.ModTime().Equal(f.modTime) {
+		return
+	}
+	if err := f.reload(); err != nil {
+		f.logger.Error("failed to reload IP rules", "file", f.file, "error", err)
+	}
+}
+
+// Middleware rejects requests from addresses that do not pass the rules
+// with 403 Forbidden.
+func (f *IPFilter) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := ClientIP(r)
+		if !f.Allowed(ip) {
+			f.logger.Warn("request denied by IP filter", "client_ip", ip.String(), "method", r.Method, "path", r.URL.Path)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		f.logger.Debug("request allowed by IP filter", "client_ip", ip.String(), "method", r.Method, "path", r.URL.Path)
+		next(w, r)
+	}
+}
+
+// readIPRulesFile parses a rules file into allow and deny lists.
+func readIPRulesFile(path string) (allow, deny []string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		action, value, _ := strings.Cut(line, " ")
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(action) {
+		case "allow":
+			allow = append(allow, value)
+		case "deny":
+			deny = append(deny, value)
+		default:
+			return nil, nil, fmt.Errorf("%s:%d: expected allow or deny, got %q", path, n, action)
+		}
+	}
+	return allow, deny, scanner.Err()
+}
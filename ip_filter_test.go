@@ -0,0 +1,145 @@
+package intake
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIPTrie(t *testing.T) {
+	set, err := parsePrefixes([]string{"10.0.0.0/8", "192.0.2.7", "2001:db8::/32", "::ffff:172.16.0.0/108"})
+	if err != nil {
+		t.Fatalf("failed to parse prefixes: %v", err)
+	}
+	trie := newIPTrie(set)
+
+	cases := map[string]bool{
+		"10.1.2.3":        true,
+		"11.0.0.1":        false,
+		"192.0.2.7":       true,
+		"192.0.2.8":       false,
+		"::ffff:10.9.9.9": true,
+		"172.16.5.5":      true,
+		"172.32.0.1":      false,
+		"2001:db8:1::1":   true,
+		"2001:db9::1":     false,
+		"::a00:1":         false,
+	}
+	for ip, want := range cases {
+		if got := trie.contains(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("%s: expected %v, got %v", ip, want, got)
+		}
+	}
+
+	// An IPv4-mapped prefix shorter than /96 is kept as an IPv6 prefix.
+	wide := newIPTrie(prefixSet{netip.PrefixFrom(netip.MustParseAddr("::ffff:10.0.0.0"), 90)})
+	if !wide.contains(netip.MustParseAddr("::ffc0:0:1")) {
+		t.Errorf("expected the short mapped prefix to be stored as IPv6")
+	}
+}
+
+func TestIPFilterEmptyAllowList(t *testing.T) {
+	filter, err := NewIPFilter(IPFilterConfig{Allow: []string{}})
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+	if filter.Allowed(netip.MustParseAddr("203.0.113.1")) {
+		t.Fatalf("expected an empty allow list to deny every address")
+	}
+}
+
+func TestIPAccess(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+	app := New()
+	admin := Endpoints{
+		GET("/admin", func(w http.ResponseWriter, r *http.Request) {}),
+	}
+	admin.Use(IPAccess(IPFilterConfig{
+		Allow:  []string{"10.8.0.0/16", "fd00:8::/32"},
+		Deny:   []string{"10.8.0.66"},
+		Logger: logger,
+	}))
+	app.AddEndpoints(admin)
+	app.AddEndpoint(http.MethodGet, "/public", func(w http.ResponseWriter, r *http.Request) {})
+
+	call := func(path, remote string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remote
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	cases := []struct {
+		path, remote string
+		want         int
+	}{
+		{"/admin", "10.8.1.2:5000", http.StatusOK},
+		{"/admin", "[fd00:8::9]:5000", http.StatusOK},
+		{"/admin", "10.8.0.66:5000", http.StatusForbidden},
+		{"/admin", "203.0.113.1:5000", http.StatusForbidden},
+		{"/public", "203.0.113.1:5000", http.StatusOK},
+	}
+	for _, tc := range cases {
+		if got := call(tc.path, tc.remote); got != tc.want {
+			t.Errorf("%s from %s: expected status %d, got %d", tc.path, tc.remote, tc.want, got)
+		}
+	}
+	if !strings.Contains(logs.String(), "client_ip=203.0.113.1") {
+		t.Errorf("expected denial to be logged with the client IP, got %q", logs.String())
+	}
+}
+
+func TestIPFilterReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	write := func(content string, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write rules: %v", err)
+		}
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	write("# VPN\nallow 10.8.0.0/16\n", time.Now().Add(-time.Hour))
+	filter, err := NewIPFilter(IPFilterConfig{File: path, ReloadInterval: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+	vpn, office := netip.MustParseAddr("10.8.3.4"), netip.MustParseAddr("198.51.100.20")
+	if !filter.Allowed(vpn) || filter.Allowed(office) {
+		t.Fatalf("unexpected initial decisions")
+	}
+
+	write("allow 10.8.0.0/16\nallow 198.51.100.0/24\ndeny 10.8.3.4\n", time.Now())
+	if filter.Allowed(vpn) || !filter.Allowed(office) {
+		t.Fatalf("expected the changed file to be reloaded")
+	}
+
+	write("permit everything\n", time.Now().Add(time.Minute))
+	if filter.Allowed(vpn) || !filter.Allowed(office) {
+		t.Fatalf("expected an invalid file to keep the previous rules")
+	}
+
+	stranger := netip.MustParseAddr("203.0.113.1")
+	write("# truncated\ndeny 10.8.3.4\n", time.Now().Add(2*time.Minute))
+	if filter.Allowed(stranger) || !filter.Allowed(office) {
+		t.Fatalf("expected a file without allow rules to keep the previous rules")
+	}
+	write("", time.Now().Add(3*time.Minute))
+	if filter.Allowed(stranger) || !filter.Allowed(office) {
+		t.Fatalf("expected an emptied file to keep the previous rules")
+	}
+
+	if err := filter.Update([]string{"not-an-ip"}, nil); err == nil {
+		t.Fatalf("expected an invalid update to fail")
+	}
+}
@@ -0,0 +1,288 @@
+// Package intake provides HTTP routing utilities.
+// This file contains the key sets used to verify JSON Web Tokens, including
+// one backed by a JSON Web Key Set (RFC 7517) document.
+package intake
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// KeySet provides the keys used to verify token signatures. Keys are
+// []byte for HMAC, *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey.
+type KeySet interface {
+	// Key returns the key identified by kid, the token's key ID header.
+	// kid is empty when the token does not name a key.
+	Key(ctx context.Context, kid string) (any, error)
+}
+
+// StaticKeySet is a fixed KeySet mapping key IDs to keys. A key stored under
+// the empty ID is used for tokens without a kid header.
+type StaticKeySet map[string]any
+
+// Key returns the key stored under kid.
+func (s StaticKeySet) Key(ctx context.Context, kid string) (any, error) {
+	key, ok := s[kid]
+	if !ok {
+		return nil, ErrTokenKeyNotFound
+	}
+	return key, nil
+}
+
+// JWKSKeySet is a KeySet loaded from a JWKS document over HTTP. The document
+// is fetched on first use and again once it is older than the refresh
+// interval, or when a token names a key ID that is not in the cached set.
+// Concurrent lookups share a single fetch, which runs outside the lock and
+// independently of the request that started it, and a failed fetch is not
+// retried for minJWKSRefresh.
+type JWKSKeySet struct {
+	url     string
+	refresh time.Duration
+	client  *http.Client
+
+	mu       sync.Mutex
+	keys     map[string]any
+	fetched  time.Time
+	failed   time.Time
+	err      error
+	inflight *jwksFetch
+}
+
+// jwksFetch is a fetch of the JWKS document shared by every lookup that
+// waits for it.
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewJWKSKeySet creates a JWKSKeySet for the document at url.
+//
+// Parameters:
+//   - url: The location of the JWKS document
+//   - refresh: How long a fetched document is used before it is fetched
+//     again. Zero or negative values use one hour.
+//
+// Returns:
+//   - A KeySet that loads its keys from url
+func NewJWKSKeySet(url string, refresh time.Duration) *JWKSKeySet {
+	if refresh <= 0 {
+		refresh = defaultJWKSRefresh
+	}
+	return &JWKSKeySet{
+		url:     url,
+		refresh: refresh,
+		client:  &http.Client{Timeout: jwksFetchTimeout},
+	}
+}
+
+const (
+	// defaultJWKSRefresh is the refresh interval used when none is given.
+	defaultJWKSRefresh = time.Hour
+	// minJWKSRefresh bounds how often an unknown key ID or a failed fetch
+	// can trigger a fetch.
+	minJWKSRefresh = 10 * time.Second
+	// jwksFetchTimeout bounds a single fetch of the document.
+	jwksFetchTimeout = 10 * time.Second
+)
+
+// Key returns the key identified by kid, fetching the document if needed.
+func (s *JWKSKeySet) Key(ctx context.Context, kid string) (any, error) {
+	s.mu.Lock()
+	age := time.Since(s.fetched)
+	_, known := s.keys[kid]
+	stale := s.keys == nil || age > s.refresh || (!known && age > minJWKSRefresh)
+	var call *jwksFetch
+	if stale && time.Since(s.failed) >= minJWKSRefresh {
+		call = s.startFetch(ctx)
+	}
+	s.mu.Unlock()
+
+	if call != nil {
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		return nil, s.err
+	}
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, nil
+		}
+	}
+	return nil, ErrTokenKeyNotFound
+}
+
+// Refresh fetches the JWKS document immediately, or waits for a fetch that
+// is already running.
+func (s *JWKSKeySet) Refresh(ctx context.Context) error {
+	s.mu.Lock()
+	call := s.startFetch(ctx)
+	s.mu.Unlock()
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startFetch starts fetching the document in the background, unless a fetch
+// is already running, and returns the fetch to wait for. The fetch keeps the
+// values of ctx but not its cancellation, since other lookups may share it.
+// It must be called with s.mu held.
+func (s *JWKSKeySet) startFetch(ctx context.Context) *jwksFetch {
+	if s.inflight != nil {
+		return s.inflight
+	}
+	call := &jwksFetch{done: make(chan struct{})}
+	s.inflight = call
+	go func() {
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jwksFetchTimeout)
+		defer cancel()
+		keys, err := s.fetch(fetchCtx)
+
+		s.mu.Lock()
+		if err != nil {
+			s.failed = time.Now()
+			s.err = err
+		} else {
+			s.keys = keys
+			s.fetched = time.Now()
+			s.failed = time.Time{}
+			s.err = nil
+		}
+		s.inflight = nil
+		s.mu.Unlock()
+
+		call.err = err
+		close(call.done)
+	}()
+	return call
+}
+
+// fetch loads the document and returns the keys it contains.
+func (s *JWKSKeySet) fetch(ctx context.Context) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decoding JWKS: %w", err)
+	}
+
+	keys := make(map[string]any, len(doc.Keys))
+	for _, jwk := range doc.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Skip keys we cannot use rather than failing the whole set.
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+// jsonWebKey is the subset of RFC 7517 key parameters needed for verification.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// publicKey converts the JWK into a key usable by verifySignature.
+func (k jsonWebKey) publicKey() (any, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("jwk %q: invalid RSA exponent", k.Kid)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("jwk %q: unsupported curve %q", k.Kid, k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("jwk %q: point is not on curve", k.Kid)
+		}
+		return pub, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("jwk %q: unsupported curve %q", k.Kid, k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("jwk %q: invalid Ed25519 key", k.Kid)
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		return decode(k.K)
+	}
+	return nil, fmt.Errorf("jwk %q: unsupported key type %q", k.Kid, k.Kty)
+}
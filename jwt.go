@@ -0,0 +1,394 @@
+// Package intake provides HTTP routing utilities.
+// This file contains middleware that authenticates requests with JSON Web
+// Tokens (RFC 7519) using only the standard library.
+package intake
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Errors returned when a token fails verification.
+var (
+	ErrTokenMalformed   = errors.New("token is malformed")
+	ErrTokenAlgorithm   = errors.New("token algorithm is not allowed")
+	ErrTokenSignature   = errors.New("token signature is invalid")
+	ErrTokenExpired     = errors.New("token is expired")
+	ErrTokenNotYetValid = errors.New("token is not valid yet")
+	ErrTokenIssuer      = errors.New("token issuer is not accepted")
+	ErrTokenAudience    = errors.New("token audience is not accepted")
+	ErrTokenKeyNotFound = errors.New("token signing key not found")
+)
+
+// Claims holds the verified claims of a JSON Web Token.
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+
+	// Raw contains every claim in the token, including the registered ones.
+	// Numbers are decoded as json.Number.
+	Raw map[string]any
+}
+
+// String returns the named claim if it is a string.
+func (c *Claims) String(name string) (string, bool) {
+	s, ok := c.Raw[name].(string)
+	return s, ok
+}
+
+// Strings returns the named claim if it is a string or an array of strings.
+func (c *Claims) Strings(name string) ([]string, bool) {
+	switch v := c.Raw[name].(type) {
+	case string:
+		return []string{v}, true
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			out = append(out, s)
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// Int64 returns the named claim if it is an integer.
+func (c *Claims) Int64(name string) (int64, bool) {
+	n, ok := c.Raw[name].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	i, err := n.Int64()
+	return i, err == nil
+}
+
+// Scopes returns the OAuth scopes granted by the token, read from a space
+// separated "scope" claim or a "scp" array.
+func (c *Claims) Scopes() []string {
+	if scope, ok := c.String("scope"); ok {
+		return strings.Fields(scope)
+	}
+	scopes, _ := c.Strings("scp")
+	return scopes
+}
+
+type jwtClaimsKey struct{}
+
+// ClaimsFromContext returns the claims placed on the context by the JWT middleware.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(jwtClaimsKey{}).(*Claims)
+	return claims, ok
+}
+
+// JWTConfig defines the configuration options for the JWT middleware.
+type JWTConfig struct {
+	// Keys provides the verification keys. Use StaticKeySet for fixed keys or
+	// NewJWKSKeySet to load them from a JWKS endpoint.
+	Keys KeySet
+
+	// Algorithms lists the accepted signing algorithms. Default value accepts
+	// HS256, HS384, HS512, RS256, ES256 and EdDSA. The key type must always
+	// match the algorithm, so an HMAC token can never be checked against a
+	// public key.
+	Algorithms []string
+
+	// Issuer, if set, must equal the token's iss claim.
+	Issuer string
+
+	// Audience, if set, must be one of the token's aud values.
+	Audience string
+
+	// Leeway is the clock skew tolerated when checking exp, nbf and iat.
+	Leeway time.Duration
+
+	// Realm is reported in the WWW-Authenticate header of 401 responses.
+	Realm string
+
+	// RolesClaim names the claim holding the subject's roles, as a string or
+	// an array of strings. Default value is "roles".
+	RolesClaim string
+}
+
+// JWT returns a middleware that requires a valid bearer token in the
+// Authorization header. Verified claims are available to later handlers
+// through ClaimsFromContext, and the token's subject, scopes and roles through
+// PrincipalFromContext. Requests without a valid token are rejected
+// with 401 Unauthorized and a WWW-Authenticate header describing the error.
+//
+// Parameters:
+//   - config: The JWTConfig struct containing the verification configuration
+//
+// Returns:
+//   - A MiddleWare function that can be applied to HTTP handlers
+func JWT(config JWTConfig) MiddleWare {
+	if config.RolesClaim == "" {
+		config.RolesClaim = "roles"
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") || token == "" {
+				unauthorized(w, config.Realm, "")
+				return
+			}
+
+			claims, err := VerifyJWT(r.Context(), strings.TrimSpace(token), config)
+			if err != nil {
+				unauthorized(w, config.Realm, tokenErrorDescription(err))
+				return
+			}
+
+			roles, _ := claims.Strings(config.RolesClaim)
+			ctx := context.WithValue(r.Context(), jwtClaimsKey{}, claims)
+			ctx = WithPrincipal(ctx, &Principal{
+				Subject: claims.Subject,
+				Scopes:  claims.Scopes(),
+				Roles:   roles,
+			})
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// unauthorized writes a 401 response with a Bearer challenge (RFC 6750).
+// An empty description means no credentials were supplied.
+func unauthorized(w http.ResponseWriter, realm, description string) {
+	challenge := "Bearer"
+	var params []string
+	if realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", realm))
+	}
+	if description != "" {
+		params = append(params, `error="invalid_token"`, fmt.Sprintf("error_description=%q", description))
+	}
+	if len(params) > 0 {
+		challenge += " " + strings.Join(params, ", ")
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// tokenErrorDescription returns a client-safe description of a verification
+// error. Errors other than the exported token errors, such as a failure to
+// fetch keys, are not exposed.
+func tokenErrorDescription(err error) string {
+	for _, known := range []error{
+		ErrTokenMalformed, ErrTokenAlgorithm, ErrTokenSignature, ErrTokenExpired,
+		ErrTokenNotYetValid, ErrTokenIssuer, ErrTokenAudience, ErrTokenKeyNotFound,
+	} {
+		if errors.Is(err, known) {
+			return known.Error()
+		}
+	}
+	return "token could not be verified"
+}
+
+// VerifyJWT verifies a compact serialized token against config and returns
+// its claims.
+//
+// Parameters:
+//   - ctx: The context used when keys must be fetched
+//   - token: The compact serialized JWT
+//   - config: The JWTConfig struct containing the verification configuration
+//
+// Returns:
+//   - The verified claims, or an error describing why verification failed
+func VerifyJWT(ctx context.Context, token string, config JWTConfig) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	algorithms := config.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = []string{"HS256", "HS384", "HS512", "RS256", "ES256", "EdDSA"}
+	}
+	if !slices.Contains(algorithms, header.Alg) {
+		return nil, ErrTokenAlgorithm
+	}
+	if config.Keys == nil {
+		return nil, ErrTokenKeyNotFound
+	}
+	key, err := config.Keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]any)
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	claims, err := newClaims(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateClaims(claims, config); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// decodeSegment decodes a base64url JSON segment into v, keeping numbers exact.
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// verifySignature checks signature over signed with key using alg. The key
+// type must match the algorithm family.
+func verifySignature(alg string, key any, signed, signature []byte) error {
+	switch alg {
+	case "HS256", "HS384", "HS512":
+		secret, ok := key.([]byte)
+		if !ok {
+			return ErrTokenAlgorithm
+		}
+		newHash := sha256.New
+		switch alg {
+		case "HS384":
+			newHash = sha512.New384
+		case "HS512":
+			newHash = sha512.New
+		}
+		mac := hmac.New(newHash, secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrTokenSignature
+		}
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrTokenAlgorithm
+		}
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+			return ErrTokenSignature
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve.Params().BitSize != 256 {
+			return ErrTokenAlgorithm
+		}
+		if len(signature) != 64 {
+			return ErrTokenSignature
+		}
+		digest := sha256.Sum256(signed)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return ErrTokenSignature
+		}
+	case "EdDSA":
+		pub, ok := key.(ed25519.PublicKey)
+		// ed25519.Verify panics on keys of the wrong length.
+		if !ok || len(pub) != ed25519.PublicKeySize {
+			return ErrTokenAlgorithm
+		}
+		if !ed25519.Verify(pub, signed, signature) {
+			return ErrTokenSignature
+		}
+	default:
+		return ErrTokenAlgorithm
+	}
+	return nil
+}
+
+// newClaims extracts the registered claims from raw.
+func newClaims(raw map[string]any) (*Claims, error) {
+	claims := &Claims{Raw: raw}
+	claims.Issuer, _ = claims.String("iss")
+	claims.Subject, _ = claims.String("sub")
+	claims.ID, _ = claims.String("jti")
+	if _, ok := raw["aud"]; ok {
+		aud, ok := claims.Strings("aud")
+		if !ok {
+			return nil, ErrTokenMalformed
+		}
+		claims.Audience = aud
+	}
+	for name, dst := range map[string]*time.Time{"exp": &claims.ExpiresAt, "nbf": &claims.NotBefore, "iat": &claims.IssuedAt} {
+		if _, ok := raw[name]; !ok {
+			continue
+		}
+		n, ok := raw[name].(json.Number)
+		if !ok {
+			return nil, ErrTokenMalformed
+		}
+		seconds, err := n.Float64()
+		// Larger values would overflow int64 when converted.
+		if err != nil || math.IsNaN(seconds) || math.Abs(seconds) >= 1<<62 {
+			return nil, ErrTokenMalformed
+		}
+		whole := math.Floor(seconds)
+		*dst = time.Unix(int64(whole), int64((seconds-whole)*float64(time.Second)))
+	}
+	return claims, nil
+}
+
+// validateClaims checks the time based and identity claims against config.
+func validateClaims(claims *Claims, config JWTConfig) error {
+	now := time.Now()
+	if !claims.ExpiresAt.IsZero() && !now.Before(claims.ExpiresAt.Add(config.Leeway)) {
+		return ErrTokenExpired
+	}
+	if !claims.NotBefore.IsZero() && now.Add(config.Leeway).Before(claims.NotBefore) {
+		return ErrTokenNotYetValid
+	}
+	if !claims.IssuedAt.IsZero() && now.Add(config.Leeway).Before(claims.IssuedAt) {
+		return ErrTokenNotYetValid
+	}
+	if config.Issuer != "" && claims.Issuer != config.Issuer {
+		return ErrTokenIssuer
+	}
+	if config.Audience != "" && !slices.Contains(claims.Audience, config.Audience) {
+		return ErrTokenAudience
+	}
+	return nil
+}
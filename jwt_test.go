@@ -0,0 +1,357 @@
+package intake
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// signTestJWT builds a compact JWT signed with key using alg.
+func signTestJWT(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	header := map[string]any{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	encode := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("failed to marshal: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+
+	var signature []byte
+	var err error
+	switch alg {
+	case "HS256", "HS512":
+		h := sha256.New
+		if alg == "HS512" {
+			h = sha512.New
+		}
+		mac := hmac.New(h, key.([]byte))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case "RS256":
+		digest := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+	case "ES256":
+		digest := sha256.Sum256([]byte(signed))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	case "EdDSA":
+		signature = ed25519.Sign(key.(ed25519.PrivateKey), []byte(signed))
+	}
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWT(t *testing.T) {
+	secret := []byte("super-secret")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	config := JWTConfig{
+		Keys: StaticKeySet{
+			"hmac": secret,
+			"rsa":  &rsaKey.PublicKey,
+			"ec":   &ecKey.PublicKey,
+			"ed":   edPub,
+		},
+		Issuer:   "https://issuer.example.com",
+		Audience: "api",
+		Leeway:   30 * time.Second,
+		Realm:    "intake",
+	}
+
+	app := New()
+	app.AddEndpoint(http.MethodGet, "/me", func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := ClaimsFromContext(r.Context())
+		if !ok {
+			t.Error("expected claims on context")
+			return
+		}
+		name, _ := claims.String("name")
+		w.Write([]byte(claims.Subject + ":" + name + ":" + strings.Join(claims.Scopes(), ",")))
+	}, JWT(config))
+
+	now := time.Now().Unix()
+	valid := func() map[string]any {
+		return map[string]any{
+			"iss":   "https://issuer.example.com",
+			"aud":   []string{"api", "other"},
+			"sub":   "user-1",
+			"name":  "Ada",
+			"scope": "orders:read orders:write",
+			"iat":   now,
+			"exp":   now + 60,
+		}
+	}
+	with := func(key string, value any) map[string]any {
+		claims := valid()
+		claims[key] = value
+		return claims
+	}
+
+	call := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("accepts supported algorithms", func(t *testing.T) {
+		tokens := map[string]string{
+			"HS256": signTestJWT(t, "HS256", "hmac", secret, valid()),
+			"HS512": signTestJWT(t, "HS512", "hmac", secret, valid()),
+			"RS256": signTestJWT(t, "RS256", "rsa", rsaKey, valid()),
+			"ES256": signTestJWT(t, "ES256", "ec", ecKey, valid()),
+			"EdDSA": signTestJWT(t, "EdDSA", "ed", edKey, valid()),
+		}
+		for alg, token := range tokens {
+			rr := call(token)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("%s: expected status %d, got %d (%s)", alg, http.StatusOK, rr.Code, rr.Header().Get("WWW-Authenticate"))
+			}
+			if got := rr.Body.String(); got != "user-1:Ada:orders:read,orders:write" {
+				t.Fatalf("%s: unexpected claims %q", alg, got)
+			}
+		}
+	})
+
+	t.Run("rejects invalid tokens", func(t *testing.T) {
+		payload, _ := json.Marshal(valid())
+		noneToken := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"hmac"}`)) + "." +
+			base64.RawURLEncoding.EncodeToString(payload) + "."
+		cases := map[string]struct {
+			token string
+			want  string
+		}{
+			"expired":         {signTestJWT(t, "HS256", "hmac", secret, with("exp", now-60)), ErrTokenExpired.Error()},
+			"not yet valid":   {signTestJWT(t, "HS256", "hmac", secret, with("nbf", now+120)), ErrTokenNotYetValid.Error()},
+			"wrong issuer":    {signTestJWT(t, "HS256", "hmac", secret, with("iss", "https://evil.example.com")), ErrTokenIssuer.Error()},
+			"wrong audience":  {signTestJWT(t, "HS256", "hmac", secret, with("aud", "other")), ErrTokenAudience.Error()},
+			"bad signature":   {signTestJWT(t, "HS256", "hmac", []byte("wrong"), valid()), ErrTokenSignature.Error()},
+			"unknown key":     {signTestJWT(t, "HS256", "missing", secret, valid()), ErrTokenKeyNotFound.Error()},
+			"alg mismatch":    {signTestJWT(t, "HS256", "rsa", secret, valid()), ErrTokenAlgorithm.Error()},
+			"alg none":        {noneToken, ErrTokenAlgorithm.Error()},
+			"overflowing exp": {signTestJWT(t, "HS256", "hmac", secret, with("exp", 1e300)), ErrTokenMalformed.Error()},
+		}
+		for name, tc := range cases {
+			rr := call(tc.token)
+			if rr.Code != http.StatusUnauthorized {
+				t.Fatalf("%s: expected status %d, got %d", name, http.StatusUnauthorized, rr.Code)
+			}
+			challenge := rr.Header().Get("WWW-Authenticate")
+			if !strings.Contains(challenge, `error="invalid_token"`) || !strings.Contains(challenge, tc.want) {
+				t.Fatalf("%s: unexpected challenge %q", name, challenge)
+			}
+		}
+	})
+
+	t.Run("tolerates clock skew", func(t *testing.T) {
+		if rr := call(signTestJWT(t, "HS256", "hmac", secret, with("exp", now-10))); rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+		}
+	})
+
+	t.Run("challenges missing credentials", func(t *testing.T) {
+		rr := call("")
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+		if got := rr.Header().Get("WWW-Authenticate"); got != `Bearer realm="intake"` {
+			t.Fatalf("unexpected challenge %q", got)
+		}
+	})
+}
+
+func TestJWKSKeySet(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rotated, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	ecJWK := func(kid string, key *ecdsa.PrivateKey) map[string]string {
+		return map[string]string{
+			"kty": "EC", "crv": "P-256", "kid": kid, "use": "sig",
+			"x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}
+	}
+	keys := []map[string]string{
+		ecJWK("ec-1", ecKey),
+		{"kty": "OKP", "crv": "Ed25519", "kid": "ed-1", "x": base64.RawURLEncoding.EncodeToString(edPub)},
+	}
+
+	var mu sync.Mutex
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		mu.Lock()
+		defer mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	defer server.Close()
+
+	keySet := NewJWKSKeySet(server.URL, time.Hour)
+	config := JWTConfig{Keys: keySet}
+	claims := map[string]any{"sub": "svc", "exp": time.Now().Add(time.Minute).Unix()}
+
+	for kid, token := range map[string]string{
+		"ec-1": signTestJWT(t, "ES256", "ec-1", ecKey, claims),
+		"ed-1": signTestJWT(t, "EdDSA", "ed-1", edKey, claims),
+	} {
+		if _, err := VerifyJWT(t.Context(), token, config); err != nil {
+			t.Fatalf("%s: expected token to verify, got %v", kid, err)
+		}
+	}
+	if got := fetches.Load(); got != 1 {
+		t.Fatalf("expected JWKS to be fetched once, got %d", got)
+	}
+
+	// Rotate keys on the server and refresh.
+	mu.Lock()
+	keys = []map[string]string{ecJWK("ec-2", rotated)}
+	mu.Unlock()
+	if err := keySet.Refresh(t.Context()); err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	if _, err := VerifyJWT(t.Context(), signTestJWT(t, "ES256", "ec-2", rotated, claims), config); err != nil {
+		t.Fatalf("expected rotated key to verify, got %v", err)
+	}
+	if _, err := VerifyJWT(t.Context(), signTestJWT(t, "ES256", "ec-1", ecKey, claims), config); err == nil {
+		t.Fatalf("expected retired key to be rejected")
+	}
+}
+
+func TestJWKSKeySetFailures(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	doc := map[string]any{"keys": []map[string]string{{
+		"kty": "EC", "crv": "P-256", "kid": "ec-1",
+		"x": base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+		"y": base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))),
+	}}}
+
+	var fail atomic.Bool
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		if fail.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(doc)
+	}))
+	defer server.Close()
+	keySet := NewJWKSKeySet(server.URL, time.Hour)
+
+	t.Run("concurrent lookups share one fetch", func(t *testing.T) {
+		// The first caller gives up, but the fetch it started completes
+		// for the others.
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		if _, err := keySet.Key(ctx, "ec-1"); err == nil {
+			t.Fatalf("expected canceled lookup to fail")
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := keySet.Key(t.Context(), "ec-1")
+				errs <- err
+			}()
+		}
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatalf("expected key, got %v", err)
+			}
+		}
+		if got := fetches.Load(); got != 1 {
+			t.Fatalf("expected one fetch, got %d", got)
+		}
+	})
+
+	t.Run("zero refresh uses the default", func(t *testing.T) {
+		zero := NewJWKSKeySet(server.URL, 0)
+		if zero.refresh != defaultJWKSRefresh {
+			t.Fatalf("expected refresh %v, got %v", defaultJWKSRefresh, zero.refresh)
+		}
+		before := fetches.Load()
+		for range 3 {
+			if _, err := zero.Key(t.Context(), "ec-1"); err != nil {
+				t.Fatalf("expected key, got %v", err)
+			}
+		}
+		if got := fetches.Load() - before; got != 1 {
+			t.Fatalf("expected one fetch, got %d", got)
+		}
+	})
+
+	t.Run("failed refresh backs off", func(t *testing.T) {
+		fail.Store(true)
+		keySet.mu.Lock()
+		keySet.fetched = time.Now().Add(-2 * time.Hour)
+		keySet.mu.Unlock()
+
+		before := fetches.Load()
+		for range 5 {
+			if _, err := keySet.Key(t.Context(), "ec-1"); err != nil {
+				t.Fatalf("expected cached key after failed refresh, got %v", err)
+			}
+		}
+		if got := fetches.Load() - before; got != 1 {
+			t.Fatalf("expected one fetch attempt, got %d", got)
+		}
+	})
+}
+
+func TestJWTShortEd25519Key(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	token := signTestJWT(t, "EdDSA", "ed", edKey, map[string]any{"sub": "svc"})
+
+	config := JWTConfig{Keys: StaticKeySet{"ed": ed25519.PublicKey(make([]byte, 16))}}
+	if _, err := VerifyJWT(t.Context(), token, config); err == nil {
+		t.Fatalf("expected a short key to be rejected")
+	}
+
+	short := jsonWebKey{Kty: "OKP", Crv: "Ed25519", Kid: "ed", X: base64.RawURLEncoding.EncodeToString(make([]byte, 16))}
+	if _, err := short.publicKey(); err == nil {
+		t.Fatalf("expected a short JWK to be rejected")
+	}
+}
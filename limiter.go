@@ -0,0 +1,250 @@
+// Package intake provides HTTP routing utilities.
+// This file contains a concurrency limiting middleware with optional adaptive
+// limit discovery and priority based load shedding.
+package intake
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Priority classifies a request for load shedding purposes.
+type Priority int
+
+const (
+	// PriorityLow requests are shed as soon as the limit is reached; they are never queued.
+	PriorityLow Priority = iota
+	// PriorityNormal requests are queued for up to QueueTimeout when the limit is reached.
+	PriorityNormal
+	// PriorityCritical requests bypass the limiter entirely and are never shed.
+	// Use it for health checks and administrative routes.
+	PriorityCritical
+)
+
+// LimiterConfig defines the configuration options for the concurrency limiter.
+// A zero MaxInFlight disables the fixed limit, so requests are only limited
+// by the adaptive bounds, or not at all if Adaptive is off; use
+// DefaultLimiterConfig as a starting point.
+type LimiterConfig struct {
+	// MaxInFlight is the maximum number of requests processed concurrently.
+	// When Adaptive is enabled this is the initial limit; if it is zero, the
+	// adaptive limit starts at MaxLimit.
+	MaxInFlight int
+
+	// QueueSize is the maximum number of requests waiting for a slot.
+	// Requests beyond this are shed immediately.
+	QueueSize int
+
+	// QueueTimeout is how long a queued request waits for a slot before it is shed.
+	QueueTimeout time.Duration
+
+	// RetryAfter is the value sent in the Retry-After header of shed responses.
+	// It is rounded up to whole seconds. Zero omits the header.
+	RetryAfter time.Duration
+
+	// Priority classifies each request. If nil, every request is PriorityNormal.
+	Priority func(r *http.Request) Priority
+
+	// Adaptive enables AIMD limit discovery. The limit grows additively while
+	// observed latency stays under LatencyTarget and shrinks multiplicatively by
+	// Backoff when it does not.
+	Adaptive bool
+
+	// LatencyTarget is the latency above which the adaptive limit is reduced.
+	LatencyTarget time.Duration
+
+	// MinLimit and MaxLimit bound the adaptive limit. MinLimit defaults to 1.
+	// MaxLimit defaults to MaxInFlight, or to 1000 if MaxInFlight is zero.
+	MinLimit int
+	MaxLimit int
+
+	// Backoff is the multiplicative decrease factor applied to the adaptive
+	// limit on overload. It must be between 0 and 1; the default is 0.9.
+	Backoff float64
+}
+
+// DefaultLimiterConfig returns a limiter configuration with common settings.
+// The default configuration:
+// - Allows 100 in-flight requests
+// - Queues up to 100 requests for at most one second
+// - Asks shed clients to retry after one second
+func DefaultLimiterConfig() LimiterConfig {
+	return LimiterConfig{
+		MaxInFlight:   100,
+		QueueSize:     100,
+		QueueTimeout:  time.Second,
+		RetryAfter:    time.Second,
+		LatencyTarget: 250 * time.Millisecond,
+		MinLimit:      1,
+		MaxLimit:      1000,
+		Backoff:       0.9,
+	}
+}
+
+// Limiter caps the number of requests that are processed concurrently.
+// A single Limiter is shared by every route it is applied to, so create one
+// per route or per Endpoints group that should have an independent budget.
+type Limiter struct {
+	config LimiterConfig
+
+	mu       sync.Mutex
+	inFlight int
+	limit    float64
+	waiters  *list.List
+}
+
+// NewLimiter creates a Limiter from the given configuration.
+func NewLimiter(config LimiterConfig) *Limiter {
+	unlimited := config.MaxInFlight <= 0
+	if config.MinLimit <= 0 {
+		config.MinLimit = 1
+	}
+	if config.MaxLimit < config.MinLimit {
+		config.MaxLimit = max(config.MaxInFlight, config.MinLimit)
+		if unlimited {
+			config.MaxLimit = max(DefaultLimiterConfig().MaxLimit, config.MinLimit)
+		}
+	}
+	switch {
+	case config.Adaptive:
+		if unlimited {
+			config.MaxInFlight = config.MaxLimit
+		}
+		config.MaxInFlight = min(max(config.MaxInFlight, config.MinLimit), config.MaxLimit)
+	case unlimited:
+		config.MaxInFlight = math.MaxInt32
+	}
+	if config.Backoff <= 0 || config.Backoff >= 1 {
+		config.Backoff = 0.9
+	}
+	return &Limiter{
+		config:  config,
+		limit:   float64(config.MaxInFlight),
+		waiters: list.New(),
+	}
+}
+
+// ConcurrencyLimit returns a middleware that limits concurrent requests
+// according to config. It is shorthand for NewLimiter(config).Middleware.
+//
+// Parameters:
+//   - config: The LimiterConfig struct containing the limiter configuration
+//
+// Returns:
+//   - A MiddleWare function that can be applied to HTTP handlers
+func ConcurrencyLimit(config LimiterConfig) MiddleWare {
+	return NewLimiter(config).Middleware
+}
+
+// Limit returns the current concurrency limit.
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// InFlight returns the number of requests currently being processed.
+func (l *Limiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// Middleware wraps next so that it only runs when a slot is available.
+// Requests that cannot acquire a slot are answered with 503 Service Unavailable
+// and a Retry-After header.
+func (l *Limiter) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		priority := PriorityNormal
+		if l.config.Priority != nil {
+			priority = l.config.Priority(r)
+		}
+		if priority == PriorityCritical {
+			next(w, r)
+			return
+		}
+
+		if !l.acquire(r, priority) {
+			l.shed(w)
+			return
+		}
+
+		start := time.Now()
+		defer func() {
+			l.release(time.Since(start))
+		}()
+		next(w, r)
+	}
+}
+
+func (l *Limiter) acquire(r *http.Request, priority Priority) bool {
+	l.mu.Lock()
+	if l.inFlight < int(l.limit) {
+		l.inFlight++
+		l.mu.Unlock()
+		return true
+	}
+	if priority == PriorityLow || l.waiters.Len() >= l.config.QueueSize || l.config.QueueTimeout <= 0 {
+		l.mu.Unlock()
+		return false
+	}
+
+	ready := make(chan struct{})
+	elem := l.waiters.PushBack(ready)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.config.QueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready:
+		// The slot was handed over while we were giving up; keep it.
+		return true
+	default:
+		l.waiters.Remove(elem)
+		return false
+	}
+}
+
+func (l *Limiter) release(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	saturated := l.inFlight >= int(l.limit)
+	l.inFlight--
+
+	if l.config.Adaptive {
+		if l.config.LatencyTarget > 0 && latency > l.config.LatencyTarget {
+			l.limit = max(l.limit*l.config.Backoff, float64(l.config.MinLimit))
+		} else if saturated {
+			l.limit = min(l.limit+1/l.limit, float64(l.config.MaxLimit))
+		}
+	}
+
+	for l.inFlight < int(l.limit) && l.waiters.Len() > 0 {
+		ready := l.waiters.Remove(l.waiters.Front()).(chan struct{})
+		l.inFlight++
+		close(ready)
+	}
+}
+
+func (l *Limiter) shed(w http.ResponseWriter) {
+	if l.config.RetryAfter > 0 {
+		seconds := int((l.config.RetryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
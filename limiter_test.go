@@ -0,0 +1,216 @@
+package intake
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimitSheds(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+
+	app := New()
+	app.AddEndpoint(http.MethodGet, "/slow", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}, ConcurrencyLimit(LimiterConfig{
+		MaxInFlight: 1,
+		RetryAfter:  1500 * time.Millisecond,
+	}))
+
+	var wg sync.WaitGroup
+	first := httptest.NewRecorder()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		app.Mux.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	}()
+	<-started
+
+	rr := httptest.NewRecorder()
+	app.Mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("expected Retry-After %q, got %q", "2", got)
+	}
+
+	close(release)
+	wg.Wait()
+	if first.Code != http.StatusOK {
+		t.Fatalf("expected first request status %d, got %d", http.StatusOK, first.Code)
+	}
+}
+
+func TestConcurrencyLimitQueues(t *testing.T) {
+	limiter := NewLimiter(LimiterConfig{
+		MaxInFlight:  1,
+		QueueSize:    1,
+		QueueTimeout: time.Second,
+	})
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+
+	handler := limiter.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rr := httptest.NewRecorder()
+			handler(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+			codes[i] = rr.Code
+		}()
+		if i == 0 {
+			<-started
+		}
+	}
+
+	// Wait for the second request to be queued.
+	for {
+		limiter.mu.Lock()
+		queued := limiter.waiters.Len()
+		limiter.mu.Unlock()
+		if queued == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("request %d: expected status %d, got %d", i, http.StatusOK, code)
+		}
+	}
+	if got := limiter.InFlight(); got != 0 {
+		t.Fatalf("expected no in-flight requests, got %d", got)
+	}
+}
+
+func TestConcurrencyLimitPriority(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+
+	limiter := NewLimiter(LimiterConfig{
+		MaxInFlight:  1,
+		QueueSize:    10,
+		QueueTimeout: time.Second,
+		Priority: func(r *http.Request) Priority {
+			switch r.URL.Path {
+			case "/healthz":
+				return PriorityCritical
+			case "/batch":
+				return PriorityLow
+			}
+			return PriorityNormal
+		},
+	})
+	handler := limiter.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/busy" {
+			started <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/busy", nil))
+		close(done)
+	}()
+	<-started
+	defer func() {
+		close(release)
+		<-done
+	}()
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected critical request status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/batch", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected low priority request status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
+
+func TestConcurrencyLimitAdaptive(t *testing.T) {
+	limiter := NewLimiter(LimiterConfig{
+		MaxInFlight:   10,
+		Adaptive:      true,
+		LatencyTarget: 10 * time.Millisecond,
+		MinLimit:      2,
+		MaxLimit:      20,
+		Backoff:       0.5,
+	})
+
+	limiter.inFlight = 10
+	limiter.release(time.Millisecond)
+	if got := limiter.limit; got <= 10 {
+		t.Fatalf("expected limit to grow above 10, got %v", got)
+	}
+
+	for range 10 {
+		limiter.inFlight = 1
+		limiter.release(time.Second)
+	}
+	if got := limiter.Limit(); got != 2 {
+		t.Fatalf("expected limit to back off to the minimum 2, got %d", got)
+	}
+}
+
+func TestConcurrencyLimitZeroMaxInFlight(t *testing.T) {
+	limiter := NewLimiter(LimiterConfig{})
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	var wg sync.WaitGroup
+	handler := limiter.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		started.Done()
+		<-release
+	})
+	for range 5 {
+		started.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+	}
+	// Every request runs concurrently instead of being serialized.
+	started.Wait()
+	if got := limiter.InFlight(); got != 5 {
+		t.Fatalf("expected 5 requests in flight, got %d", got)
+	}
+	close(release)
+	wg.Wait()
+
+	adaptive := NewLimiter(LimiterConfig{Adaptive: true, MaxLimit: 50})
+	if got := adaptive.Limit(); got != 50 {
+		t.Fatalf("expected adaptive limit to start at MaxLimit, got %d", got)
+	}
+	if got := NewLimiter(LimiterConfig{Adaptive: true}).Limit(); got != 1000 {
+		t.Fatalf("expected adaptive limit to default to 1000, got %d", got)
+	}
+	if got := NewLimiter(LimiterConfig{Adaptive: true, MaxInFlight: 500, MinLimit: 5, MaxLimit: 20}).Limit(); got != 20 {
+		t.Fatalf("expected MaxInFlight to be clamped to MaxLimit, got %d", got)
+	}
+	if got := NewLimiter(LimiterConfig{Adaptive: true, MaxInFlight: 2, MinLimit: 5, MaxLimit: 20}).Limit(); got != 5 {
+		t.Fatalf("expected MaxInFlight to be clamped to MinLimit, got %d", got)
+	}
+}
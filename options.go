@@ -0,0 +1,124 @@
+// Package intake provides HTTP routing utilities.
+// This file contains the OPTIONS handlers added by AddOptionsEndpoints,
+// which report the methods of each path and can describe it for discovery.
+package intake
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// OptionsConfig defines the configuration options for the OPTIONS handlers
+// added by AddOptionsEndpoints.
+type OptionsConfig struct {
+	// Discovery answers OPTIONS requests that are not CORS preflights with
+	// 200 OK and a JSON ResourceDescription listing the methods of the path.
+	// By default they get 204 No Content.
+	Discovery bool
+
+	// DisclosePermissions adds the scopes and roles each method requires to
+	// the discovery description. OPTIONS requests are not authenticated, so
+	// this reveals the authorization model to anonymous clients; only enable
+	// it for internal services. Default is false.
+	DisclosePermissions bool
+}
+
+// ResourceDescription is the body of a discovery OPTIONS response.
+type ResourceDescription struct {
+	// Path is the URL pattern of the resource
+	Path string `json:"path"`
+	// Methods describes each method allowed on the resource
+	Methods []MethodDescription `json:"methods"`
+}
+
+// MethodDescription describes one method of a resource.
+type MethodDescription struct {
+	// Method is the HTTP method
+	Method string `json:"method"`
+	// Scopes are the scopes a principal must hold to call the method; only
+	// set with OptionsConfig.DisclosePermissions
+	Scopes []string `json:"scopes,omitempty"`
+	// Roles are the roles of which a principal must hold at least one; only
+	// set with OptionsConfig.DisclosePermissions
+	Roles []string `json:"roles,omitempty"`
+}
+
+// reserveOptions records an OPTIONS endpoint for path and returns the slot
+// its handler will be stored in. It must be called with a.mu held.
+func (a *Intake) reserveOptions(path string) *atomic.Pointer[http.HandlerFunc] {
+	if a.autoOptions == nil {
+		a.autoOptions = make(map[string]*atomic.Pointer[http.HandlerFunc])
+	}
+	slot := &atomic.Pointer[http.HandlerFunc]{}
+	a.autoOptions[path] = slot
+	a.registeredRoutes[path] = append(a.registeredRoutes[path], http.MethodOptions)
+	return slot
+}
+
+// registerOptions builds the generated OPTIONS endpoint for path, with the
+// global middleware, and registers it with the mux through slot.
+func (a *Intake) registerOptions(path string, slot *atomic.Pointer[http.HandlerFunc]) {
+	handler := a.buildHandler(NewEndpoint(http.MethodOptions, path, a.optionsHandler(path)))
+	// An explicit OPTIONS endpoint may already have taken the slot.
+	slot.CompareAndSwap(nil, &handler)
+	a.Mux.HandleFunc(http.MethodOptions+" "+path, func(w http.ResponseWriter, r *http.Request) {
+		(*slot.Load())(w, r)
+	})
+}
+
+// optionsHandler answers OPTIONS requests for path with an Allow header
+// and, if discovery is enabled, a description of the resource.
+func (a *Intake) optionsHandler(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		methods := a.allowedMethods(path)
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+
+		a.mu.RLock()
+		var config OptionsConfig
+		if a.optionsConfig != nil {
+			config = *a.optionsConfig
+		}
+		a.mu.RUnlock()
+		if !config.Discovery || r.Header.Get("Access-Control-Request-Method") != "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		RespondJSON(w, r, http.StatusOK, a.describeResource(path, methods, config.DisclosePermissions))
+	}
+}
+
+// allowedMethods returns the sorted methods registered for path, including
+// HEAD, which http.ServeMux serves for every GET route.
+func (a *Intake) allowedMethods(path string) []string {
+	a.mu.RLock()
+	methods := slices.Clone(a.registeredRoutes[path])
+	a.mu.RUnlock()
+	if slices.Contains(methods, http.MethodGet) {
+		methods = append(methods, http.MethodHead)
+	}
+	slices.Sort(methods)
+	return slices.Compact(methods)
+}
+
+// describeResource returns the discovery description of path, with the
+// scopes and roles of each method if permissions is set.
+func (a *Intake) describeResource(path string, methods []string, permissions bool) ResourceDescription {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	desc := ResourceDescription{Path: path, Methods: make([]MethodDescription, 0, len(methods))}
+	for _, method := range methods {
+		opts := a.routeOptions[method+" "+path]
+		if method == http.MethodHead && !slices.Contains(a.registeredRoutes[path], http.MethodHead) {
+			opts = a.routeOptions[http.MethodGet+" "+path]
+		}
+		m := MethodDescription{Method: method}
+		if opts != nil && permissions {
+			m.Scopes = slices.Clone(opts.scopes)
+			m.Roles = slices.Clone(opts.roles)
+		}
+		desc.Methods = append(desc.Methods, m)
+	}
+	return desc
+}
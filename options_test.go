@@ -0,0 +1,138 @@
+package intake
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAddOptionsEndpoints(t *testing.T) {
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	options := func(app *Intake, path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("allow header", func(t *testing.T) {
+		app := New()
+		app.AddEndpoint(http.MethodGet, "/users", noop)
+		app.AddEndpoint(http.MethodPost, "/users", noop)
+		app.AddOptionsEndpoints()
+
+		rr := options(app, "/users", nil)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("expected status %d, got %d", http.StatusNoContent, rr.Code)
+		}
+		if got := rr.Header().Get("Allow"); got != "GET, HEAD, OPTIONS, POST" {
+			t.Fatalf("unexpected Allow header %q", got)
+		}
+	})
+
+	t.Run("lazy registration", func(t *testing.T) {
+		app := New()
+		app.AddOptionsEndpoints()
+		users := Endpoints{
+			GET("/users/{id}", noop),
+			DELETE("/users/{id}", noop),
+		}
+		users.Use(func(next http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		})
+		app.AddEndpoints(users)
+
+		rr := options(app, "/users/7", nil)
+		if rr.Code != http.StatusNoContent || rr.Header().Get("Allow") != "DELETE, GET, HEAD, OPTIONS" {
+			t.Fatalf("unexpected response %d %q", rr.Code, rr.Header().Get("Allow"))
+		}
+
+		app.AddEndpoint(http.MethodPut, "/users/{id}", noop)
+		if got := options(app, "/users/7", nil).Header().Get("Allow"); got != "DELETE, GET, HEAD, OPTIONS, PUT" {
+			t.Fatalf("expected methods added later to be reported, got %q", got)
+		}
+	})
+
+	t.Run("explicit handler replaces generated one", func(t *testing.T) {
+		app := New()
+		app.AddEndpoint(http.MethodGet, "/status", noop)
+		app.AddOptionsEndpoints()
+		app.AddEndpoint(http.MethodOptions, "/status", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+
+		if rr := options(app, "/status", nil); rr.Code != http.StatusTeapot {
+			t.Fatalf("expected the explicit handler, got %d", rr.Code)
+		}
+		if methods := app.GetRoutes()["/status"]; len(methods) != 2 {
+			t.Fatalf("expected OPTIONS to be registered once, got %v", methods)
+		}
+	})
+
+	t.Run("discovery", func(t *testing.T) {
+		app := New()
+		app.AddOptionsEndpoints(OptionsConfig{Discovery: true})
+		app.AddEndpoints(Endpoints{
+			GET("/orders", noop).With(WithScopes("orders:read")),
+			POST("/orders", noop).With(WithScopes("orders:write")),
+		})
+
+		rr := options(app, "/orders", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+		}
+		var desc ResourceDescription
+		if err := json.NewDecoder(rr.Body).Decode(&desc); err != nil {
+			t.Fatalf("failed to decode body: %v", err)
+		}
+		if desc.Path != "/orders" || len(desc.Methods) != 4 {
+			t.Fatalf("unexpected description %+v", desc)
+		}
+		for _, m := range desc.Methods {
+			if len(m.Scopes) > 0 || len(m.Roles) > 0 {
+				t.Fatalf("expected permissions not to be disclosed by default, got %+v", m)
+			}
+		}
+
+		rr = options(app, "/orders", map[string]string{"Access-Control-Request-Method": http.MethodPost})
+		if rr.Code != http.StatusNoContent || rr.Body.Len() != 0 {
+			t.Fatalf("expected preflights to get no body, got %d %q", rr.Code, rr.Body.String())
+		}
+
+		app.AddOptionsEndpoints(OptionsConfig{Discovery: true, DisclosePermissions: true})
+		desc = ResourceDescription{}
+		if err := json.NewDecoder(options(app, "/orders", nil).Body).Decode(&desc); err != nil {
+			t.Fatalf("failed to decode body: %v", err)
+		}
+		if head := desc.Methods[1]; head.Method != http.MethodHead || len(head.Scopes) != 1 || head.Scopes[0] != "orders:read" {
+			t.Fatalf("expected HEAD to require the GET scopes, got %+v", head)
+		}
+	})
+
+	t.Run("per-route CORS", func(t *testing.T) {
+		app := New()
+		app.AddGlobalMiddleware(CORS(CORSConfig{AllowedOrigins: []string{"*"}}))
+		app.AddOptionsEndpoints()
+		app.AddEndpoints(Endpoints{
+			POST("/account", noop).With(WithCORS(CORSConfig{
+				AllowedOrigins:   []string{"https://app.example.com"},
+				AllowedMethods:   []string{http.MethodPost},
+				AllowCredentials: true,
+			})),
+		})
+
+		rr := options(app, "/account", map[string]string{
+			"Origin":                        "https://app.example.com",
+			"Access-Control-Request-Method": http.MethodPost,
+		})
+		if rr.Header().Get("Access-Control-Allow-Credentials") != "true" {
+			t.Fatalf("expected the route policy to answer the preflight, got %v", rr.Header())
+		}
+	})
+}
@@ -0,0 +1,370 @@
+// Package intake provides HTTP routing utilities.
+// This file contains a net.Listener that understands the HAProxy PROXY
+// protocol (versions 1 and 2), so servers behind TCP load balancers see the
+// original client addresses.
+package intake
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrProxyHeader is returned when reading from a connection whose PROXY
+// protocol header is malformed or did not arrive in time.
+var ErrProxyHeader = errors.New("invalid PROXY protocol header")
+
+// PROXY protocol v2 TLV types.
+const (
+	ProxyTLVALPN      byte = 0x01
+	ProxyTLVAuthority byte = 0x02
+	ProxyTLVUniqueID  byte = 0x05
+	ProxyTLVSSL       byte = 0x20
+	ProxyTLVNetNS     byte = 0x30
+	ProxyTLVAWS       byte = 0xEA
+)
+
+// proxyV2Signature starts every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyTLV is a type-length-value field of a PROXY protocol v2 header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyHeader is a parsed PROXY protocol header.
+type ProxyHeader struct {
+	// Version is 1 or 2.
+	Version int
+	// Source and Destination are the addresses of the original connection.
+	// They are nil when the proxy sent no address information, e.g. for its
+	// own health checks.
+	Source      net.Addr
+	Destination net.Addr
+	// TLVs holds the v2 extension fields in the order they were sent.
+	TLVs []ProxyTLV
+}
+
+// TLV returns the value of the first TLV of type t.
+func (h *ProxyHeader) TLV(t byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// SNI returns the TLS server name the client requested, sent by the proxy
+// as the authority TLV.
+func (h *ProxyHeader) SNI() string {
+	value, _ := h.TLV(ProxyTLVAuthority)
+	return string(value)
+}
+
+// AWSVPCEndpointID returns the ID of the AWS VPC endpoint the connection
+// arrived through, sent by AWS Network Load Balancers.
+func (h *ProxyHeader) AWSVPCEndpointID() string {
+	value, ok := h.TLV(ProxyTLVAWS)
+	if !ok || len(value) == 0 || value[0] != 0x01 {
+		return ""
+	}
+	return string(value[1:])
+}
+
+// ProxyProtocolConfig defines the configuration options for a ProxyListener.
+type ProxyProtocolConfig struct {
+	// TrustedSources lists the load balancers allowed to send PROXY headers,
+	// as CIDRs or single IPs. Connections from other addresses are served
+	// as-is, so a client cannot claim an arbitrary address. Connections from
+	// a trusted source without a header are also served as-is.
+	TrustedSources []string
+
+	// HeaderTimeout bounds how long to wait for the header once a connection
+	// is accepted. Default is 5 seconds.
+	HeaderTimeout time.Duration
+}
+
+// ProxyListener wraps a net.Listener and strips PROXY protocol headers from
+// accepted connections. The connections report the original client and
+// server addresses from RemoteAddr and LocalAddr, so r.RemoteAddr is the
+// client's address. Headers are read on the connection's first use rather
+// than in Accept, so a slow client cannot stall the accept loop.
+type ProxyListener struct {
+	net.Listener
+	trusted prefixSet
+	timeout time.Duration
+}
+
+// NewProxyListener wraps inner to accept PROXY protocol headers.
+//
+// Parameters:
+//   - inner: The listener accepting connections from the load balancer
+//   - config: The ProxyProtocolConfig struct containing the listener configuration
+//
+// Returns:
+//   - The wrapping listener, or an error if a trusted source is invalid
+func NewProxyListener(inner net.Listener, config ProxyProtocolConfig) (*ProxyListener, error) {
+	trusted, err := parsePrefixes(config.TrustedSources)
+	if err != nil {
+		return nil, err
+	}
+	if config.HeaderTimeout <= 0 {
+		config.HeaderTimeout = 5 * time.Second
+	}
+	return &ProxyListener{Listener: inner, trusted: trusted, timeout: config.HeaderTimeout}, nil
+}
+
+// Accept returns the next connection. Connections from trusted sources are
+// wrapped in a *ProxyConn.
+func (l *ProxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	addrPort, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil || !l.trusted.contains(addrPort.Addr()) {
+		return conn, nil
+	}
+	return &ProxyConn{Conn: conn, reader: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+// ProxyConn is a connection accepted by a ProxyListener from a trusted source.
+type ProxyConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	header *ProxyHeader
+	err    error
+}
+
+// Header returns the connection's PROXY header, reading it if necessary. It
+// returns nil if the source sent no header.
+func (c *ProxyConn) Header() (*ProxyHeader, error) {
+	c.once.Do(c.readHeader)
+	return c.header, c.err
+}
+
+func (c *ProxyConn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	c.header, c.err = readProxyHeader(c.reader)
+	if c.err != nil {
+		c.err = fmt.Errorf("%w: %v", ErrProxyHeader, c.err)
+	}
+}
+
+// Read reads data following the PROXY header.
+func (c *ProxyConn) Read(b []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the original client address, or the peer address if
+// the header carries none.
+func (c *ProxyConn) RemoteAddr() net.Addr {
+	if h, _ := c.Header(); h != nil && h.Source != nil {
+		return h.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the original destination address, or the local address
+// if the header carries none.
+func (c *ProxyConn) LocalAddr() net.Addr {
+	if h, _ := c.Header(); h != nil && h.Destination != nil {
+		return h.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+type proxyConnKey struct{}
+
+// ProxyConnContext records c in ctx so handlers can read its PROXY header
+// with ProxyHeaderFromContext. Set it as the http.Server's ConnContext;
+// RunListener does this automatically. It does not block, as net/http calls
+// it from the accept loop.
+func ProxyConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(interface{ NetConn() net.Conn }); ok {
+		// Unwrap TLS connections served on top of a ProxyListener.
+		c = tc.NetConn()
+	}
+	if pc, ok := c.(*ProxyConn); ok {
+		return context.WithValue(ctx, proxyConnKey{}, pc)
+	}
+	return ctx
+}
+
+// ProxyHeaderFromContext returns the PROXY header of the connection a
+// request arrived on.
+func ProxyHeaderFromContext(ctx context.Context) (*ProxyHeader, bool) {
+	pc, ok := ctx.Value(proxyConnKey{}).(*ProxyConn)
+	if !ok {
+		return nil, false
+	}
+	h, err := pc.Header()
+	return h, err == nil && h != nil
+}
+
+// readProxyHeader reads a v1 or v2 header from r. It returns nil without
+// consuming anything if the data does not start with a header.
+func readProxyHeader(r *bufio.Reader) (*ProxyHeader, error) {
+	prefix, err := r.Peek(len(proxyV2Signature))
+	if errors.Is(err, io.EOF) {
+		// Too short to be a header; let the reader see the data and EOF.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(prefix, proxyV2Signature):
+		return readProxyHeaderV2(r)
+	case bytes.HasPrefix(prefix, []byte("PROXY ")):
+		return readProxyHeaderV1(r)
+	}
+	return nil, nil
+}
+
+// readProxyHeaderV1 parses a text header such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyHeaderV1(r *bufio.Reader) (*ProxyHeader, error) {
+	// A v1 header is at most 107 bytes including the CRLF.
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errors.New("v1 header is not terminated")
+	}
+
+	fields := strings.Split(text, " ")
+	header := &ProxyHeader{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return header, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed v1 header %q", text)
+	}
+	src, err := parseProxyV1Addr(fields[2], fields[4], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseProxyV1Addr(fields[3], fields[5], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	header.Source, header.Destination = src, dst
+	return header, nil
+}
+
+func parseProxyV1Addr(ip, port string, v4 bool) (net.Addr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Is4() != v4 {
+		return nil, fmt.Errorf("invalid v1 address %q", ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("invalid v1 port %q", port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+// readProxyHeaderV2 parses a binary header.
+func readProxyHeaderV2(r *bufio.Reader) (*ProxyHeader, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported version %d", fixed[12]>>4)
+	}
+	command := fixed[12] & 0x0F
+	family := fixed[13]
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	header := &ProxyHeader{Version: 2}
+	var addrLen int
+	switch family >> 4 {
+	case 0x1:
+		addrLen = 12
+	case 0x2:
+		addrLen = 36
+	case 0x3:
+		addrLen = 216
+	}
+	if len(payload) < addrLen {
+		return nil, errors.New("v2 address block is truncated")
+	}
+
+	switch command {
+	case 0x0:
+		// LOCAL: the proxy's own connection, e.g. a health check.
+	case 0x1:
+		transport := family & 0x0F
+		switch family >> 4 {
+		case 0x1, 0x2:
+			ipLen := 4
+			if family>>4 == 0x2 {
+				ipLen = 16
+			}
+			src, _ := netip.AddrFromSlice(payload[:ipLen])
+			dst, _ := netip.AddrFromSlice(payload[ipLen : 2*ipLen])
+			srcPort := binary.BigEndian.Uint16(payload[2*ipLen:])
+			dstPort := binary.BigEndian.Uint16(payload[2*ipLen+2:])
+			if transport == 0x2 {
+				header.Source = net.UDPAddrFromAddrPort(netip.AddrPortFrom(src, srcPort))
+				header.Destination = net.UDPAddrFromAddrPort(netip.AddrPortFrom(dst, dstPort))
+			} else {
+				header.Source = net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, srcPort))
+				header.Destination = net.TCPAddrFromAddrPort(netip.AddrPortFrom(dst, dstPort))
+			}
+		case 0x3:
+			header.Source = &net.UnixAddr{Name: string(bytes.TrimRight(payload[:108], "\x00")), Net: "unix"}
+			header.Destination = &net.UnixAddr{Name: string(bytes.TrimRight(payload[108:216], "\x00")), Net: "unix"}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported command %d", command)
+	}
+
+	tlvs := payload[addrLen:]
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, errors.New("v2 TLV is truncated")
+		}
+		n := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+n {
+			return nil, errors.New("v2 TLV is truncated")
+		}
+		header.TLVs = append(header.TLVs, ProxyTLV{Type: tlvs[0], Value: tlvs[3 : 3+n]})
+		tlvs = tlvs[3+n:]
+	}
+	return header, nil
+}
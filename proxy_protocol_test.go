@@ -0,0 +1,166 @@
+package intake
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyV2Header builds a v2 PROXY header for a TCP connection.
+func proxyV2Header(src, dst netip.AddrPort, tlvs ...ProxyTLV) []byte {
+	family := byte(0x11)
+	if src.Addr().Is6() {
+		family = 0x21
+	}
+	var payload bytes.Buffer
+	payload.Write(src.Addr().AsSlice())
+	payload.Write(dst.Addr().AsSlice())
+	binary.Write(&payload, binary.BigEndian, src.Port())
+	binary.Write(&payload, binary.BigEndian, dst.Port())
+	for _, tlv := range tlvs {
+		payload.WriteByte(tlv.Type)
+		binary.Write(&payload, binary.BigEndian, uint16(len(tlv.Value)))
+		payload.Write(tlv.Value)
+	}
+
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x21, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(payload.Len()))
+	return append(header, payload.Bytes()...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	t.Run("v1", func(t *testing.T) {
+		r := bufio.NewReader(strings.NewReader("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET / HTTP/1.1\r\n"))
+		h, err := readProxyHeader(r)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if h.Version != 1 || h.Source.String() != "192.0.2.1:56324" || h.Destination.String() != "198.51.100.1:443" {
+			t.Fatalf("unexpected header %+v", h)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != "GET / HTTP/1.1\r\n" {
+			t.Fatalf("expected the request to follow the header, got %q", rest)
+		}
+	})
+
+	t.Run("v2 with TLVs", func(t *testing.T) {
+		data := proxyV2Header(
+			netip.MustParseAddrPort("[2001:db8::1]:40000"),
+			netip.MustParseAddrPort("[2001:db8::2]:443"),
+			ProxyTLV{Type: ProxyTLVAuthority, Value: []byte("api.example.com")},
+			ProxyTLV{Type: ProxyTLVAWS, Value: append([]byte{0x01}, "vpce-0123"...)},
+		)
+		h, err := readProxyHeader(bufio.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if h.Version != 2 || h.Source.String() != "[2001:db8::1]:40000" {
+			t.Fatalf("unexpected header %+v", h)
+		}
+		if h.SNI() != "api.example.com" || h.AWSVPCEndpointID() != "vpce-0123" {
+			t.Fatalf("unexpected TLVs %q %q", h.SNI(), h.AWSVPCEndpointID())
+		}
+	})
+
+	t.Run("no header", func(t *testing.T) {
+		r := bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))
+		if h, err := readProxyHeader(r); h != nil || err != nil {
+			t.Fatalf("expected no header, got %+v, %v", h, err)
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		for _, input := range []string{
+			"PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n",
+			"PROXY TCP4 2001:db8::1 198.51.100.1 56324 443\r\n",
+			"PROXY TCP4 192.0.2.1 198.51.100.1 99999 443\r\n",
+			string(proxyV2Signature) + "\x21\x11\x00\x20",
+		} {
+			if _, err := readProxyHeader(bufio.NewReader(strings.NewReader(input))); err == nil {
+				t.Errorf("expected an error for %q", input)
+			}
+		}
+	})
+}
+
+func TestProxyListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	listener, err := NewProxyListener(inner, ProxyProtocolConfig{
+		TrustedSources: []string{"127.0.0.1"},
+		HeaderTimeout:  200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+
+	app := New()
+	app.AddEndpoint(http.MethodGet, "/", func(w http.ResponseWriter, r *http.Request) {
+		sni := ""
+		if h, ok := ProxyHeaderFromContext(r.Context()); ok {
+			sni = h.SNI()
+		}
+		w.Write([]byte(r.RemoteAddr + " " + sni))
+	})
+	server := &http.Server{Handler: app.Mux, ConnContext: ProxyConnContext}
+	go server.Serve(listener)
+	defer server.Close()
+
+	send := func(header []byte) (string, error) {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		conn.Write(header)
+		conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	body, err := send([]byte("PROXY TCP4 203.0.113.9 10.0.0.1 51000 80\r\n"))
+	if err != nil || body != "203.0.113.9:51000 " {
+		t.Fatalf("v1: unexpected response %q, %v", body, err)
+	}
+
+	body, err = send(proxyV2Header(
+		netip.MustParseAddrPort("198.51.100.4:6000"),
+		netip.MustParseAddrPort("10.0.0.1:443"),
+		ProxyTLV{Type: ProxyTLVAuthority, Value: []byte("shop.example.com")},
+	))
+	if err != nil || body != "198.51.100.4:6000 shop.example.com" {
+		t.Fatalf("v2: unexpected response %q, %v", body, err)
+	}
+
+	body, err = send(nil)
+	if err != nil || !strings.HasPrefix(body, "127.0.0.1:") {
+		t.Fatalf("no header: unexpected response %q, %v", body, err)
+	}
+
+	// A trusted source that never completes its header is disconnected.
+	conn, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("PROXY TCP4 "))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"net/http"
+	"time"
 )
 
 // RespondJSON writes a JSON response with the specified HTTP status code.
@@ -66,3 +67,26 @@ func Respond(w http.ResponseWriter, r *http.Request, code int, data []byte) (int
 	w.WriteHeader(code)
 	return w.Write(data)
 }
+
+// SetETag sets the ETag response header. The ETag middleware uses a tag set
+// by the handler instead of hashing the response body, which saves hashing
+// it when the handler already knows the resource version. The body is still
+// buffered.
+//
+// Parameters:
+//   - w: The HTTP response writer to set the header on
+//   - tag: The opaque version identifier, quoted or unquoted
+//   - weak: Whether the entity tag is weak
+func SetETag(w http.ResponseWriter, tag string, weak bool) {
+	w.Header().Set("ETag", FormatETag(tag, weak))
+}
+
+// SetLastModified sets the Last-Modified response header so that
+// If-Modified-Since and If-Unmodified-Since can be evaluated.
+//
+// Parameters:
+//   - w: The HTTP response writer to set the header on
+//   - t: The time the resource was last modified
+func SetLastModified(w http.ResponseWriter, t time.Time) {
+	w.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+}
@@ -0,0 +1,66 @@
+// Package intake provides HTTP routing utilities.
+// This file contains an in-memory response writer used by middleware that
+// needs to inspect, delay or replay a handler's response.
+package intake
+
+import (
+	"bytes"
+	"net/http"
+	"slices"
+)
+
+// responseBuffer is an http.ResponseWriter that records the status code,
+// headers and body written by a handler instead of sending them.
+type responseBuffer struct {
+	header      http.Header
+	code        int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: make(http.Header)}
+}
+
+// Header returns the header map that will be sent by writeTo.
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+// WriteHeader records the status code. Only the first call has an effect.
+func (b *responseBuffer) WriteHeader(code int) {
+	if b.wroteHeader {
+		return
+	}
+	b.code = code
+	b.wroteHeader = true
+}
+
+// Write appends data to the recorded body.
+func (b *responseBuffer) Write(data []byte) (int, error) {
+	if !b.wroteHeader {
+		b.WriteHeader(http.StatusOK)
+	}
+	return b.body.Write(data)
+}
+
+// status returns the recorded status code, defaulting to 200 OK.
+func (b *responseBuffer) status() int {
+	if !b.wroteHeader {
+		return http.StatusOK
+	}
+	return b.code
+}
+
+// writeTo sends the recorded response to w. Headers already present on w are
+// kept unless the handler set the same header. The buffer is not modified, so
+// the same response can be written to several writers.
+func (b *responseBuffer) writeTo(w http.ResponseWriter) error {
+	dst := w.Header()
+	for key, values := range b.header {
+		dst[key] = slices.Clone(values)
+	}
+	w.WriteHeader(b.status())
+	_, err := w.Write(b.body.Bytes())
+	return err
+}
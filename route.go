@@ -0,0 +1,90 @@
+// Package intake provides HTTP routing utilities.
+// This file contains per-route options that can be attached to endpoints and
+// read back by the built-in middleware while a request is being served.
+package intake
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// EndpointOption configures a per-route setting on an endpoint.
+// Options are attached with endpoint.With or Endpoints.With, e.g.
+//
+//	intake.GET("/report", report).With(intake.WithTimeout(5 * time.Minute))
+type EndpointOption func(*routeOptions)
+
+// routeOptions holds the per-route settings attached to an endpoint.
+type routeOptions struct {
+	// timeout overrides the Timeout middleware for this route when non-zero
+	timeout time.Duration
+	// noTimeout exempts the route from any Timeout middleware
+	noTimeout bool
+	// bodyLimit overrides the BodyLimit middleware for this route when set
+	bodyLimit *BodyLimitConfig
+	// scopes must all be held by the principal calling the route
+	scopes []string
+	// roles lists the roles of which the principal must hold at least one
+	roles []string
+	// noCSRF exempts the route from the CSRF middleware
+	noCSRF bool
+	// cors replaces any global CORS middleware for this route when set
+	cors *CORSHandler
+}
+
+type routeOptionsKey struct{}
+
+// clone returns a copy of o so endpoints never share mutable options.
+func (o *routeOptions) clone() *routeOptions {
+	if o == nil {
+		return &routeOptions{}
+	}
+	c := *o
+	return &c
+}
+
+// requiresAuthorization reports whether the route has scope or role requirements.
+func (o *routeOptions) requiresAuthorization() bool {
+	return o != nil && (len(o.scopes) > 0 || len(o.roles) > 0)
+}
+
+// routeOptionsFrom returns the options of the route serving r, or nil if the
+// route has none.
+func routeOptionsFrom(r *http.Request) *routeOptions {
+	opts, _ := r.Context().Value(routeOptionsKey{}).(*routeOptions)
+	return opts
+}
+
+// withRouteOptions returns a middleware that makes opts available to the
+// rest of the chain through the request context.
+func withRouteOptions(opts *routeOptions) MiddleWare {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), routeOptionsKey{}, opts)
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// WithTimeout sets a request timeout for a single route. It takes precedence
+// over any Timeout middleware, so a slow route can be given more time than
+// the global default and a fast one less.
+//
+// Parameters:
+//   - d: The maximum duration the route's handler may run
+func WithTimeout(d time.Duration) EndpointOption {
+	return func(o *routeOptions) {
+		o.timeout = d
+		o.noTimeout = false
+	}
+}
+
+// WithoutTimeout exempts a route from the Timeout middleware. Use it for
+// streaming endpoints that write incrementally and must not be buffered.
+func WithoutTimeout() EndpointOption {
+	return func(o *routeOptions) {
+		o.timeout = 0
+		o.noTimeout = true
+	}
+}
@@ -0,0 +1,87 @@
+package intake
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	app := New()
+	app.AddGlobalMiddleware(SecurityHeaders(APISecurityHeadersConfig()))
+	app.AddEndpoint(http.MethodGet, "/api", func(w http.ResponseWriter, r *http.Request) {
+		if CSPNonce(r) != "" {
+			t.Error("expected no nonce for the API preset")
+		}
+		w.Write([]byte("{}"))
+	})
+	app.AddEndpoint(http.MethodGet, "/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<script nonce="` + CSPNonce(r) + `"></script>`))
+	}, SecurityHeaders(HTMLSecurityHeadersConfig()))
+
+	call := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	t.Run("api preset", func(t *testing.T) {
+		rr := call("/api")
+		want := map[string]string{
+			"Strict-Transport-Security":    "max-age=63072000; includeSubDomains",
+			"X-Content-Type-Options":       "nosniff",
+			"X-Frame-Options":              "DENY",
+			"Referrer-Policy":              "no-referrer",
+			"Cross-Origin-Opener-Policy":   "same-origin",
+			"Cross-Origin-Resource-Policy": "same-origin",
+			"Content-Security-Policy":      "default-src 'none'; frame-ancestors 'none'",
+			"Permissions-Policy":           "",
+		}
+		for name, value := range want {
+			if got := rr.Header().Get(name); got != value {
+				t.Errorf("%s: expected %q, got %q", name, value, got)
+			}
+		}
+	})
+
+	t.Run("route override with nonce", func(t *testing.T) {
+		rr := call("/page")
+		csp := rr.Header().Get("Content-Security-Policy")
+		body := rr.Body.String()
+		nonce := strings.TrimSuffix(strings.TrimPrefix(body, `<script nonce="`), `"></script>`)
+		if nonce == "" || nonce == body {
+			t.Fatalf("expected a nonce in the body, got %q", body)
+		}
+		for _, directive := range []string{"script-src 'self' 'nonce-" + nonce + "'", "style-src 'self' 'nonce-" + nonce + "'", "object-src 'none'"} {
+			if !strings.Contains(csp, directive) {
+				t.Errorf("expected %q in policy %q", directive, csp)
+			}
+		}
+		if got := rr.Header().Get("Referrer-Policy"); got != "strict-origin-when-cross-origin" {
+			t.Errorf("expected route config to replace global headers, got %q", got)
+		}
+		if got := rr.Header().Values("Content-Security-Policy"); len(got) != 1 {
+			t.Errorf("expected a single policy, got %q", got)
+		}
+
+		if again := call("/page").Header().Get("Content-Security-Policy"); again == csp {
+			t.Errorf("expected a fresh nonce per request")
+		}
+	})
+}
+
+func TestBuildCSP(t *testing.T) {
+	got := buildCSP(CSPConfig{
+		Directives: map[string][]string{
+			"default-src":               {"'none'"},
+			"img-src":                   {"'self'"},
+			"upgrade-insecure-requests": nil,
+		},
+		Nonce: true,
+	})
+	want := "default-src 'none'; img-src 'self'; script-src 'nonce-\x00'; style-src 'nonce-\x00'; upgrade-insecure-requests"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,438 @@
+// Package intake provides HTTP routing utilities.
+// This file contains the session middleware and the API handlers use to
+// read and modify the current session.
+package intake
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SessionConfig defines the configuration options for the Sessions middleware.
+// At most one of Codec and Store may be set; with neither, sessions are kept
+// in a MemorySessionStore.
+type SessionConfig struct {
+	// Codec stores the whole session in the cookie, signed with
+	// NewSignedCodec or encrypted with NewEncryptedCodec.
+	Codec SessionCodec
+
+	// Store keeps sessions on the server; the cookie only holds the ID.
+	// Default value is a MemorySessionStore if Codec is not set either.
+	Store SessionStore
+
+	// CookieName is the name of the session cookie. Default value is "session".
+	CookieName string
+
+	// CookiePath and CookieDomain scope the cookie. Default path is "/".
+	CookiePath   string
+	CookieDomain string
+
+	// SameSite is the cookie's SameSite attribute. Default value is Lax.
+	SameSite http.SameSite
+
+	// InsecureCookie omits the Secure attribute, for local development over
+	// plain HTTP.
+	InsecureCookie bool
+
+	// IdleTimeout ends a session that has not been used for this long.
+	// Default is 30 minutes.
+	IdleTimeout time.Duration
+
+	// AbsoluteTimeout ends a session this long after it was created,
+	// however active it is. Default is 24 hours.
+	AbsoluteTimeout time.Duration
+}
+
+// DefaultSessionConfig returns a session configuration with common settings.
+// The default configuration:
+// - Stores sessions in memory on the server
+// - Uses a Secure, HttpOnly, SameSite=Lax cookie named "session"
+// - Expires sessions after 30 minutes of inactivity or 24 hours in total
+func DefaultSessionConfig() SessionConfig {
+	return SessionConfig{
+		CookieName:      "session",
+		CookiePath:      "/",
+		SameSite:        http.SameSiteLaxMode,
+		IdleTimeout:     30 * time.Minute,
+		AbsoluteTimeout: 24 * time.Hour,
+	}
+}
+
+// sessionRecord is the persisted form of a session.
+type sessionRecord struct {
+	ID        string         `json:"id"`
+	Values    map[string]any `json:"values,omitempty"`
+	Flashes   []string       `json:"flashes,omitempty"`
+	CreatedAt time.Time      `json:"created"`
+	LastSeen  time.Time      `json:"seen"`
+}
+
+// SessionData is the session of the current request, returned by Session.
+// Values are persisted as JSON, so they come back as JSON types: numbers
+// are float64, objects map[string]any. It is safe for concurrent use.
+//
+// The session is saved when the response header is written, since the
+// cookie cannot change afterwards, so make changes before writing the
+// response. Later changes to a session kept in a SessionStore are saved
+// once the handler returns, except for RenewID; later changes to a session
+// kept in the cookie are lost. Either failure is logged through
+// slog.Default.
+type SessionData struct {
+	mu        sync.Mutex
+	record    sessionRecord
+	staleIDs  []string
+	isNew     bool
+	modified  bool
+	destroyed bool
+	// savedID is the ID sent in the cookie once the session is saved
+	savedID string
+}
+
+type sessionKey struct{}
+
+// Session returns the session of the current request, or nil if the
+// Sessions middleware is not installed. Changes should be made before the
+// response is written; see SessionData.
+func Session(r *http.Request) *SessionData {
+	s, _ := r.Context().Value(sessionKey{}).(*SessionData)
+	return s
+}
+
+// ID returns the session ID.
+func (s *SessionData) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record.ID
+}
+
+// IsNew reports whether the session was created by this request.
+func (s *SessionData) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+// Get returns the value stored under key.
+func (s *SessionData) Get(key string) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.record.Values[key]
+	return v, ok
+}
+
+// GetString returns the value stored under key if it is a string.
+func (s *SessionData) GetString(key string) string {
+	v, _ := s.Get(key)
+	str, _ := v.(string)
+	return str
+}
+
+// Set stores value under key. value must be serializable to JSON.
+func (s *SessionData) Set(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.record.Values == nil {
+		s.record.Values = make(map[string]any)
+	}
+	s.record.Values[key] = value
+	s.modified = true
+}
+
+// Delete removes the value stored under key.
+func (s *SessionData) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.record.Values[key]; ok {
+		delete(s.record.Values, key)
+		s.modified = true
+	}
+}
+
+// AddFlash queues a message to be shown once, typically on the next page.
+func (s *SessionData) AddFlash(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record.Flashes = append(s.record.Flashes, message)
+	s.modified = true
+}
+
+// Flashes returns the queued flash messages and removes them from the session.
+func (s *SessionData) Flashes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes := s.record.Flashes
+	if len(flashes) > 0 {
+		s.record.Flashes = nil
+		s.modified = true
+	}
+	return flashes
+}
+
+// RenewID gives the session a new ID while keeping its values. Call it
+// whenever the privilege level changes, such as on login or logout, so a
+// session ID planted by an attacker before the change is useless after it.
+func (s *SessionData) RenewID() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.staleIDs = append(s.staleIDs, s.record.ID)
+	s.record.ID = newSessionID()
+	s.modified = true
+}
+
+// Destroy deletes the session and clears its cookie.
+func (s *SessionData) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+	s.record.Values = nil
+	s.record.Flashes = nil
+	s.modified = true
+}
+
+// Sessions returns a middleware that loads the session named by the request's
+// cookie and makes it available through Session. A missing, invalid or
+// expired session is replaced by a new, empty one. The cookie is written
+// only when the session is modified, or at most every tenth of IdleTimeout
+// to keep an active session alive.
+//
+// Parameters:
+//   - config: The SessionConfig struct containing the session configuration
+//
+// Returns:
+//   - A MiddleWare function that can be applied to HTTP handlers
+func Sessions(config SessionConfig) MiddleWare {
+	if config.Codec != nil && config.Store != nil {
+		panic("intake: Sessions accepts only one of Codec and Store")
+	}
+	if config.Codec == nil && config.Store == nil {
+		config.Store = NewMemorySessionStore()
+	}
+	defaults := DefaultSessionConfig()
+	if config.CookieName == "" {
+		config.CookieName = defaults.CookieName
+	}
+	if config.CookiePath == "" {
+		config.CookiePath = defaults.CookiePath
+	}
+	if config.SameSite == 0 {
+		config.SameSite = defaults.SameSite
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = defaults.IdleTimeout
+	}
+	if config.AbsoluteTimeout <= 0 {
+		config.AbsoluteTimeout = defaults.AbsoluteTimeout
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			session := loadSession(r, config)
+			sw := &sessionWriter{ResponseWriter: w, commit: func() error {
+				return saveSession(w, r, session, config)
+			}}
+			next(sw, r.WithContext(context.WithValue(r.Context(), sessionKey{}, session)))
+			if !sw.committed {
+				sw.flushSession()
+				return
+			}
+			if sw.failed {
+				return
+			}
+			if err := saveLateChanges(r, session, config); err != nil {
+				slog.WarnContext(r.Context(), "session changes after the response was sent were lost", "error", err)
+			}
+		}
+	}
+}
+
+// loadSession reads the request's session, starting a new one if there is
+// no valid, unexpired session.
+func loadSession(r *http.Request, config SessionConfig) *SessionData {
+	now := time.Now()
+	session := &SessionData{}
+
+	if cookie, err := r.Cookie(config.CookieName); err == nil && cookie.Value != "" {
+		var data []byte
+		if config.Store != nil {
+			data, err = config.Store.Load(r.Context(), cookie.Value)
+		} else {
+			data, err = config.Codec.Decode(config.CookieName, cookie.Value)
+		}
+		var record sessionRecord
+		if err == nil && data != nil && json.Unmarshal(data, &record) == nil &&
+			(config.Store == nil || record.ID == cookie.Value) {
+			if now.Sub(record.LastSeen) < config.IdleTimeout && now.Sub(record.CreatedAt) < config.AbsoluteTimeout {
+				session.record = record
+				if now.Sub(record.LastSeen) > config.IdleTimeout/10 {
+					session.record.LastSeen = now
+					session.modified = true
+				}
+				return session
+			}
+			session.staleIDs = []string{record.ID}
+		}
+	}
+
+	session.isNew = true
+	session.record = sessionRecord{ID: newSessionID(), CreatedAt: now, LastSeen: now}
+	return session
+}
+
+// saveSession persists a modified session and writes its cookie.
+func saveSession(w http.ResponseWriter, r *http.Request, session *SessionData, config SessionConfig) error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	ctx := context.WithoutCancel(r.Context())
+	if config.Store != nil {
+		for _, id := range session.staleIDs {
+			if err := config.Store.Delete(ctx, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	cookie := &http.Cookie{
+		Name:     config.CookieName,
+		Path:     config.CookiePath,
+		Domain:   config.CookieDomain,
+		Secure:   !config.InsecureCookie,
+		HttpOnly: true,
+		SameSite: config.SameSite,
+	}
+
+	if session.destroyed {
+		if config.Store != nil {
+			if err := config.Store.Delete(ctx, session.record.ID); err != nil {
+				return err
+			}
+		}
+		if _, err := r.Cookie(config.CookieName); err == nil {
+			cookie.MaxAge = -1
+			http.SetCookie(w, cookie)
+		}
+		session.modified = false
+		return nil
+	}
+	if !session.modified {
+		session.savedID = session.record.ID
+		return nil
+	}
+
+	session.record.LastSeen = time.Now()
+	data, err := json.Marshal(session.record)
+	if err != nil {
+		return err
+	}
+	remaining := session.record.CreatedAt.Add(config.AbsoluteTimeout).Sub(session.record.LastSeen)
+	if config.Store != nil {
+		if err := config.Store.Save(ctx, session.record.ID, data, min(config.IdleTimeout, remaining)); err != nil {
+			return err
+		}
+		cookie.Value = session.record.ID
+	} else if cookie.Value, err = config.Codec.Encode(config.CookieName, data); err != nil {
+		return err
+	}
+	cookie.MaxAge = int(remaining / time.Second)
+	http.SetCookie(w, cookie)
+	session.modified = false
+	session.savedID = session.record.ID
+	return nil
+}
+
+// saveLateChanges saves changes made to a session after it was saved with
+// the response header. Only sessions in a SessionStore can still be saved,
+// under the ID their cookie already holds.
+func saveLateChanges(r *http.Request, session *SessionData, config SessionConfig) error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if !session.modified || session.savedID == "" {
+		return nil
+	}
+	if config.Store == nil {
+		return errors.New("cookie sessions cannot change after the response header is written")
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	if session.destroyed {
+		return config.Store.Delete(ctx, session.savedID)
+	}
+	if session.record.ID != session.savedID {
+		return errors.New("RenewID was called after the response header was written")
+	}
+	session.record.LastSeen = time.Now()
+	data, err := json.Marshal(session.record)
+	if err != nil {
+		return err
+	}
+	remaining := session.record.CreatedAt.Add(config.AbsoluteTimeout).Sub(session.record.LastSeen)
+	return config.Store.Save(ctx, session.record.ID, data, min(config.IdleTimeout, remaining))
+}
+
+// newSessionID returns a random 256-bit session ID.
+func newSessionID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// sessionWriter saves the session just before the response header is sent,
+// which is the last moment the cookie can still be set. If saving fails,
+// the handler's response is replaced by 500 Internal Server Error.
+type sessionWriter struct {
+	http.ResponseWriter
+	commit    func() error
+	committed bool
+	failed    bool
+}
+
+// flushSession saves the session if the handler has not written anything.
+func (sw *sessionWriter) flushSession() {
+	if sw.committed {
+		return
+	}
+	sw.committed = true
+	if err := sw.commit(); err != nil {
+		sw.failed = true
+		http.Error(sw.ResponseWriter, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+func (sw *sessionWriter) WriteHeader(code int) {
+	if code >= 100 && code <= 199 {
+		sw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	sw.flushSession()
+	if !sw.failed {
+		sw.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (sw *sessionWriter) Write(data []byte) (int, error) {
+	sw.flushSession()
+	if sw.failed {
+		return len(data), nil
+	}
+	return sw.ResponseWriter.Write(data)
+}
+
+// Flush implements http.Flusher.
+func (sw *sessionWriter) Flush() {
+	sw.flushSession()
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok && !sw.failed {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (sw *sessionWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
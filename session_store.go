@@ -0,0 +1,233 @@
+// Package intake provides HTTP routing utilities.
+// This file contains the cookie codecs and server-side stores that sessions
+// are persisted with.
+package intake
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSessionCookieInvalid is returned by a SessionCodec for a cookie that was
+// tampered with, or was encoded with a key that is no longer configured.
+var ErrSessionCookieInvalid = errors.New("session cookie is invalid")
+
+// ErrSessionTooLarge is returned when an encoded session does not fit in a
+// cookie.
+var ErrSessionTooLarge = errors.New("session is too large for a cookie")
+
+// maxSessionCookieBytes is the largest cookie value browsers reliably keep.
+const maxSessionCookieBytes = 4000
+
+// SessionCodec protects session data stored directly in a cookie.
+// Implementations must be safe for concurrent use.
+type SessionCodec interface {
+	// Encode returns the cookie value holding data. name is the cookie name,
+	// which is bound to the value so it cannot be moved to another cookie.
+	Encode(name string, data []byte) (string, error)
+	// Decode returns the data held by a cookie value produced by Encode.
+	Decode(name, value string) ([]byte, error)
+}
+
+type signedCodec struct {
+	keys [][]byte
+}
+
+// NewSignedCodec returns a SessionCodec that signs session data with
+// HMAC-SHA256. The data is readable by the client but cannot be modified.
+// The first key signs new cookies; every key is tried when verifying, so a
+// new key can be put first while cookies signed with older keys stay valid.
+//
+// Parameters:
+//   - keys: The signing keys, newest first
+//
+// Returns:
+//   - A SessionCodec for signed cookies
+func NewSignedCodec(keys ...[]byte) SessionCodec {
+	if len(keys) == 0 {
+		panic("intake: NewSignedCodec requires at least one key")
+	}
+	return &signedCodec{keys: keys}
+}
+
+func (c *signedCodec) Encode(name string, data []byte) (string, error) {
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	value := payload + "." + base64.RawURLEncoding.EncodeToString(c.mac(c.keys[0], name, payload))
+	if len(value) > maxSessionCookieBytes {
+		return "", ErrSessionTooLarge
+	}
+	return value, nil
+}
+
+func (c *signedCodec) Decode(name, value string) ([]byte, error) {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, ErrSessionCookieInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, ErrSessionCookieInvalid
+	}
+	for _, key := range c.keys {
+		if hmac.Equal(mac, c.mac(key, name, payload)) {
+			data, err := base64.RawURLEncoding.DecodeString(payload)
+			if err != nil {
+				return nil, ErrSessionCookieInvalid
+			}
+			return data, nil
+		}
+	}
+	return nil, ErrSessionCookieInvalid
+}
+
+func (c *signedCodec) mac(key []byte, name, payload string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+type encryptedCodec struct {
+	aeads []cipher.AEAD
+}
+
+// NewEncryptedCodec returns a SessionCodec that encrypts and authenticates
+// session data with AES-GCM, so the client can neither read nor modify it.
+// The first key encrypts new cookies; every key is tried when decrypting.
+//
+// Parameters:
+//   - keys: AES keys of 16, 24 or 32 bytes, newest first
+//
+// Returns:
+//   - A SessionCodec for encrypted cookies, or an error if a key is invalid
+func NewEncryptedCodec(keys ...[]byte) (SessionCodec, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("intake: NewEncryptedCodec requires at least one key")
+	}
+	c := &encryptedCodec{}
+	for i, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("intake: session key %d: %w", i, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.aeads = append(c.aeads, aead)
+	}
+	return c, nil
+}
+
+func (c *encryptedCodec) Encode(name string, data []byte) (string, error) {
+	aead := c.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	rand.Read(nonce)
+	value := base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, data, []byte(name)))
+	if len(value) > maxSessionCookieBytes {
+		return "", ErrSessionTooLarge
+	}
+	return value, nil
+}
+
+func (c *encryptedCodec) Decode(name, value string) ([]byte, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrSessionCookieInvalid
+	}
+	for _, aead := range c.aeads {
+		if len(raw) < aead.NonceSize() {
+			continue
+		}
+		nonce, ciphertext := raw[:aead.NonceSize()], raw[aead.NonceSize():]
+		if data, err := aead.Open(nil, nonce, ciphertext, []byte(name)); err == nil {
+			return data, nil
+		}
+	}
+	return nil, ErrSessionCookieInvalid
+}
+
+// SessionStore keeps session data on the server, so the cookie only holds a
+// random session ID. Implementations must be safe for concurrent use.
+type SessionStore interface {
+	// Load returns the data stored for id, or nil if there is none.
+	Load(ctx context.Context, id string) ([]byte, error)
+	// Save stores data for id, replacing any previous data, for ttl.
+	Save(ctx context.Context, id string, data []byte, ttl time.Duration) error
+	// Delete removes the data stored for id.
+	Delete(ctx context.Context, id string) error
+}
+
+// MemorySessionStore is an in-memory SessionStore whose sessions expire
+// after their TTL.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySessionItem
+	sweep    time.Time
+}
+
+type memorySessionItem struct {
+	data    []byte
+	expires time.Time
+}
+
+// NewMemorySessionStore creates an empty MemorySessionStore.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]memorySessionItem)}
+}
+
+// Load returns the data stored for id if it has not expired.
+func (s *MemorySessionStore) Load(ctx context.Context, id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.sessions[id]
+	if !ok || time.Now().After(item.expires) {
+		return nil, nil
+	}
+	return item.data, nil
+}
+
+// Save stores data for id for ttl.
+func (s *MemorySessionStore) Save(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.After(s.sweep) {
+		for k, item := range s.sessions {
+			if now.After(item.expires) {
+				delete(s.sessions, k)
+			}
+		}
+		s.sweep = now.Add(time.Minute)
+	}
+	s.sessions[id] = memorySessionItem{data: data, expires: now.Add(ttl)}
+	return nil
+}
+
+// Delete removes id.
+func (s *MemorySessionStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+// Len returns the number of stored sessions, including expired ones that
+// have not been swept yet.
+func (s *MemorySessionStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
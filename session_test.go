@@ -0,0 +1,210 @@
+package intake
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	encrypted, err := NewEncryptedCodec([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("failed to create codec: %v", err)
+	}
+	store := NewMemorySessionStore()
+
+	defaultsWithCodec := DefaultSessionConfig()
+	defaultsWithCodec.Codec = NewSignedCodec([]byte("signing-key"))
+
+	configs := map[string]SessionConfig{
+		"signed":              {Codec: NewSignedCodec([]byte("signing-key"))},
+		"encrypted":           {Codec: encrypted},
+		"store":               {Store: store},
+		"defaults":            DefaultSessionConfig(),
+		"defaults with codec": defaultsWithCodec,
+	}
+
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			app := New()
+			app.AddGlobalMiddleware(Sessions(config))
+			app.AddEndpoints(Endpoints{
+				GET("/view", func(w http.ResponseWriter, r *http.Request) {
+					s := Session(r)
+					w.Write([]byte(s.GetString("user") + "|" + strings.Join(s.Flashes(), ",")))
+				}),
+				POST("/login", func(w http.ResponseWriter, r *http.Request) {
+					s := Session(r)
+					s.RenewID()
+					s.Set("user", "ada")
+					s.AddFlash("welcome")
+				}),
+				POST("/logout", func(w http.ResponseWriter, r *http.Request) {
+					Session(r).Destroy()
+				}),
+			})
+
+			var cookie *http.Cookie
+			call := func(method, path string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(method, path, nil)
+				if cookie != nil {
+					req.AddCookie(cookie)
+				}
+				rr := httptest.NewRecorder()
+				app.Mux.ServeHTTP(rr, req)
+				if cookies := rr.Result().Cookies(); len(cookies) > 0 {
+					cookie = cookies[0]
+				}
+				return rr
+			}
+
+			if rr := call(http.MethodGet, "/view"); len(rr.Result().Cookies()) != 0 {
+				t.Fatalf("expected no cookie for an unmodified session")
+			}
+
+			call(http.MethodPost, "/login")
+			if cookie == nil || !cookie.HttpOnly || !cookie.Secure {
+				t.Fatalf("expected a secure session cookie, got %v", cookie)
+			}
+			if rr := call(http.MethodGet, "/view"); rr.Body.String() != "ada|welcome" {
+				t.Fatalf("unexpected session contents %q", rr.Body.String())
+			}
+			if rr := call(http.MethodGet, "/view"); rr.Body.String() != "ada|" {
+				t.Fatalf("expected flash to be consumed, got %q", rr.Body.String())
+			}
+
+			// A tampered cookie starts a fresh session.
+			tampered := *cookie
+			tampered.Value += "x"
+			req := httptest.NewRequest(http.MethodGet, "/view", nil)
+			req.AddCookie(&tampered)
+			rr := httptest.NewRecorder()
+			app.Mux.ServeHTTP(rr, req)
+			if rr.Body.String() != "|" {
+				t.Fatalf("expected tampered session to be rejected, got %q", rr.Body.String())
+			}
+
+			call(http.MethodPost, "/logout")
+			if cookie.MaxAge >= 0 {
+				t.Fatalf("expected logout to clear the cookie, got %v", cookie)
+			}
+		})
+	}
+
+	if store.Len() != 0 {
+		t.Fatalf("expected destroyed sessions to be removed from the store, got %d", store.Len())
+	}
+}
+
+func TestSessionRenewID(t *testing.T) {
+	store := NewMemorySessionStore()
+	app := New()
+	app.AddGlobalMiddleware(Sessions(SessionConfig{Store: store}))
+	app.AddEndpoint(http.MethodPost, "/visit", func(w http.ResponseWriter, r *http.Request) {
+		Session(r).Set("seen", true)
+	})
+	app.AddEndpoint(http.MethodPost, "/login", func(w http.ResponseWriter, r *http.Request) {
+		Session(r).RenewID()
+	})
+
+	call := func(path string, cookie *http.Cookie) *http.Cookie {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+		return rr.Result().Cookies()[0]
+	}
+
+	before := call("/visit", nil)
+	after := call("/login", before)
+	if after.Value == before.Value {
+		t.Fatalf("expected a new session ID")
+	}
+	if data, _ := store.Load(t.Context(), before.Value); data != nil {
+		t.Fatalf("expected the old session ID to be invalidated")
+	}
+	if data, _ := store.Load(t.Context(), after.Value); !strings.Contains(string(data), `"seen":true`) {
+		t.Fatalf("expected values to carry over, got %s", data)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	codec := NewSignedCodec([]byte("new-key"), []byte("old-key"))
+	oldCodec := NewSignedCodec([]byte("old-key"))
+	config := SessionConfig{Codec: codec, IdleTimeout: time.Minute, AbsoluteTimeout: time.Hour}
+
+	app := New()
+	app.AddGlobalMiddleware(Sessions(config))
+	app.AddEndpoint(http.MethodGet, "/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(Session(r).GetString("user")))
+	})
+
+	cookieFor := func(c SessionCodec, created, seen time.Time) *http.Cookie {
+		value, err := c.Encode("session", []byte(`{"id":"s1","values":{"user":"ada"},"created":"`+
+			created.Format(time.RFC3339Nano)+`","seen":"`+seen.Format(time.RFC3339Nano)+`"}`))
+		if err != nil {
+			t.Fatalf("failed to encode: %v", err)
+		}
+		return &http.Cookie{Name: "session", Value: value}
+	}
+
+	now := time.Now()
+	cases := map[string]struct {
+		cookie *http.Cookie
+		want   string
+	}{
+		"active":           {cookieFor(codec, now.Add(-10*time.Minute), now.Add(-10*time.Second)), "ada"},
+		"rotated key":      {cookieFor(oldCodec, now.Add(-10*time.Minute), now.Add(-10*time.Second)), "ada"},
+		"idle":             {cookieFor(codec, now.Add(-10*time.Minute), now.Add(-2*time.Minute)), ""},
+		"absolute timeout": {cookieFor(codec, now.Add(-2*time.Hour), now.Add(-10*time.Second)), ""},
+	}
+	for name, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(tc.cookie)
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+		if rr.Body.String() != tc.want {
+			t.Errorf("%s: expected %q, got %q", name, tc.want, rr.Body.String())
+		}
+	}
+}
+
+func TestSessionChangesAfterWrite(t *testing.T) {
+	store := NewMemorySessionStore()
+	app := New()
+	app.AddGlobalMiddleware(Sessions(SessionConfig{Store: store}))
+	app.AddEndpoint(http.MethodPost, "/start", func(w http.ResponseWriter, r *http.Request) {
+		Session(r).Set("step", "started")
+	})
+	app.AddEndpoint(http.MethodPost, "/render", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("page"))
+		// Changed after the header was sent; the cookie already holds the ID.
+		Session(r).Set("step", "rendered")
+	})
+	app.AddEndpoint(http.MethodGet, "/step", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(Session(r).GetString("step")))
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/start", nil)
+	rr := httptest.NewRecorder()
+	app.Mux.ServeHTTP(rr, req)
+	cookie := rr.Result().Cookies()[0]
+
+	for _, target := range []string{"/render", "/step"} {
+		method := http.MethodPost
+		if target == "/step" {
+			method = http.MethodGet
+		}
+		req := httptest.NewRequest(method, target, nil)
+		req.AddCookie(cookie)
+		rr = httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+	}
+	if got := rr.Body.String(); got != "rendered" {
+		t.Fatalf("expected the late change to be saved, got %q", got)
+	}
+}
@@ -0,0 +1,121 @@
+// Package intake provides HTTP routing utilities.
+// This file contains middleware that bounds how long a handler may run.
+package intake
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Timeout returns a middleware that limits how long handlers may run.
+// The request context is given a deadline of d; if the handler has not
+// finished when it expires the client receives 503 Service Unavailable.
+//
+// The handler's response is buffered until it returns so a late write can
+// never race with the timeout response; writes after the deadline fail with
+// http.ErrHandlerTimeout. Routes that stream their response should opt out
+// with WithoutTimeout, and routes that need a different limit can set one
+// with WithTimeout.
+//
+// Parameters:
+//   - d: The maximum duration a handler may run
+//
+// Returns:
+//   - A MiddleWare function that can be applied to HTTP handlers
+func Timeout(d time.Duration) MiddleWare {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		limited := timeoutHandler(next, d)
+		return func(w http.ResponseWriter, r *http.Request) {
+			// Routes with their own timeout setting are handled by the router.
+			if opts := routeOptionsFrom(r); opts != nil && (opts.noTimeout || opts.timeout > 0) {
+				next(w, r)
+				return
+			}
+			limited(w, r)
+		}
+	}
+}
+
+// timeoutHandler runs next with a deadline of d, buffering its response.
+func timeoutHandler(next http.HandlerFunc, d time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		tw := &timeoutWriter{buf: newResponseBuffer()}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			next(tw, r)
+			tw.mu.Lock()
+			// A handler that returns because the deadline passed still times out.
+			tw.finished = ctx.Err() == nil
+			tw.mu.Unlock()
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			// Re-panic on the serving goroutine so the router's panic handler sees it.
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.buf.writeTo(w)
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			if tw.finished {
+				// The handler returned just before the deadline; keep its response.
+				tw.buf.writeTo(w)
+				return
+			}
+			tw.timedOut = true
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		}
+	}
+}
+
+// timeoutWriter buffers a handler's response and rejects writes made after
+// the deadline has passed.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	buf      *responseBuffer
+	timedOut bool
+	// finished is set if the handler returned before the deadline
+	finished bool
+}
+
+// Header returns the buffered header map. The map is never read again once
+// the deadline has passed, so late changes by the handler are harmless.
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.buf.Header()
+}
+
+// Write buffers data, or returns http.ErrHandlerTimeout after the deadline.
+func (tw *timeoutWriter) Write(data []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	return tw.buf.Write(data)
+}
+
+// WriteHeader buffers the status code unless the deadline has passed.
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.buf.WriteHeader(code)
+}
@@ -0,0 +1,118 @@
+package intake
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	lateWrite := make(chan error, 1)
+
+	app := New()
+	app.AddGlobalMiddleware(Timeout(20 * time.Millisecond))
+	app.AddEndpoint(http.MethodGet, "/lookup", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		_, err := w.Write([]byte("too late"))
+		lateWrite <- err
+	})
+	app.AddEndpoint(http.MethodGet, "/fast", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "fast")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("ok"))
+	})
+
+	t.Run("responds 503 when the handler overruns", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/lookup", nil))
+
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+		}
+		if err := <-lateWrite; !errors.Is(err, http.ErrHandlerTimeout) {
+			t.Fatalf("expected late write to fail with ErrHandlerTimeout, got %v", err)
+		}
+	})
+
+	t.Run("passes through a fast response", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/fast", nil))
+
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d", http.StatusCreated, rr.Code)
+		}
+		if got := rr.Header().Get("X-Handler"); got != "fast" {
+			t.Fatalf("expected X-Handler header %q, got %q", "fast", got)
+		}
+		if got := rr.Body.String(); got != "ok" {
+			t.Fatalf("expected body %q, got %q", "ok", got)
+		}
+	})
+}
+
+func TestTimeoutRouteOptions(t *testing.T) {
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(40 * time.Millisecond):
+			w.WriteHeader(http.StatusOK)
+		case <-r.Context().Done():
+		}
+	}
+
+	app := New()
+	app.AddGlobalMiddleware(Timeout(10 * time.Millisecond))
+	app.AddEndpoints(Endpoints{
+		GET("/report", slow).With(WithTimeout(time.Second)),
+		GET("/stream", func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := w.(http.Flusher); !ok {
+				t.Error("expected streaming route to receive an http.Flusher")
+			}
+			slow(w, r)
+		}).With(WithoutTimeout()),
+		GET("/lookup", slow),
+	})
+
+	cases := []struct {
+		path string
+		want int
+	}{
+		{path: "/report", want: http.StatusOK},
+		{path: "/stream", want: http.StatusOK},
+		{path: "/lookup", want: http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rr.Code != tc.want {
+			t.Fatalf("%s: expected status %d, got %d", tc.path, tc.want, rr.Code)
+		}
+	}
+}
+
+func TestTimeoutPanicReachesPanicHandler(t *testing.T) {
+	app := New()
+	var recovered any
+	app.SetPanicHandler(func(w http.ResponseWriter, r *http.Request, err any) {
+		recovered = err
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	app.AddEndpoints(Endpoints{
+		GET("/panic", func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}).With(WithTimeout(time.Second)),
+	})
+
+	rr := httptest.NewRecorder()
+	app.Mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+	if recovered != "boom" {
+		t.Fatalf("expected panic value %q, got %v", "boom", recovered)
+	}
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status %d, got %d", http.StatusInternalServerError, rr.Code)
+	}
+}
@@ -0,0 +1,298 @@
+// Package intake provides HTTP routing utilities.
+// This file contains middleware that verifies HMAC-SHA256 signatures on
+// inbound webhook requests, with replay protection.
+package intake
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebhookScheme describes how a provider signs its webhook requests.
+type WebhookScheme struct {
+	// Extract reads the signature material from the request. timestamp is
+	// the signed Unix time in seconds, or empty if the scheme is not
+	// timestamped. nonce identifies the delivery for replay protection and
+	// may be empty, in which case the timestamp and signature are used.
+	Extract func(r *http.Request) (timestamp, nonce string, signatures [][]byte, err error)
+
+	// Payload returns the bytes the provider computed the HMAC over.
+	Payload func(timestamp string, body []byte) []byte
+}
+
+// TimestampedWebhookScheme returns the scheme where header holds a list like
+// "t=1700000000,v1=<hex>,v1=<hex>" and the HMAC is computed over the
+// timestamp, a dot and the body. Several v1 entries may be present while the
+// provider rotates secrets.
+//
+// Parameters:
+//   - header: The request header carrying the signature, e.g. "Stripe-Signature"
+func TimestampedWebhookScheme(header string) WebhookScheme {
+	return WebhookScheme{
+		Extract: func(r *http.Request) (string, string, [][]byte, error) {
+			var timestamp string
+			var signatures [][]byte
+			for _, part := range strings.Split(r.Header.Get(header), ",") {
+				name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+				switch name {
+				case "t":
+					timestamp = value
+				case "v1":
+					if sig, err := hex.DecodeString(value); err == nil {
+						signatures = append(signatures, sig)
+					}
+				}
+			}
+			if timestamp == "" {
+				return "", "", nil, errors.New("missing signature timestamp")
+			}
+			return timestamp, "", signatures, nil
+		},
+		Payload: dotSeparatedPayload,
+	}
+}
+
+// HexWebhookScheme returns the scheme where header holds the hex encoded
+// HMAC of the body, optionally after a prefix such as "sha256=". If
+// timestampHeader is set, the HMAC is instead computed over that header's
+// value, a dot and the body.
+//
+// Parameters:
+//   - header: The request header carrying the signature, e.g. "X-Hub-Signature-256"
+//   - prefix: A prefix to strip from the header value, or ""
+//   - timestampHeader: The request header carrying the signed timestamp, or ""
+func HexWebhookScheme(header, prefix, timestampHeader string) WebhookScheme {
+	scheme := WebhookScheme{
+		Extract: func(r *http.Request) (string, string, [][]byte, error) {
+			value, ok := strings.CutPrefix(r.Header.Get(header), prefix)
+			if !ok {
+				return "", "", nil, errors.New("malformed signature")
+			}
+			sig, err := hex.DecodeString(strings.TrimSpace(value))
+			if err != nil {
+				return "", "", nil, errors.New("malformed signature")
+			}
+			var timestamp string
+			if timestampHeader != "" {
+				if timestamp = r.Header.Get(timestampHeader); timestamp == "" {
+					return "", "", nil, errors.New("missing signature timestamp")
+				}
+			}
+			return timestamp, "", [][]byte{sig}, nil
+		},
+		Payload: func(timestamp string, body []byte) []byte {
+			return body
+		},
+	}
+	if timestampHeader != "" {
+		scheme.Payload = dotSeparatedPayload
+	}
+	return scheme
+}
+
+// dotSeparatedPayload returns timestamp + "." + body.
+func dotSeparatedPayload(timestamp string, body []byte) []byte {
+	payload := make([]byte, 0, len(timestamp)+1+len(body))
+	payload = append(payload, timestamp...)
+	payload = append(payload, '.')
+	return append(payload, body...)
+}
+
+// NonceStore remembers webhook deliveries that were already accepted.
+// Implementations must be safe for concurrent use and make Seen atomic.
+type NonceStore interface {
+	// Seen reports whether nonce was already recorded, and records it for
+	// ttl if it was not.
+	Seen(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+}
+
+// MemoryNonceStore is an in-memory NonceStore.
+type MemoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	sweep  time.Time
+}
+
+// NewMemoryNonceStore creates an empty MemoryNonceStore.
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{nonces: make(map[string]time.Time)}
+}
+
+// Seen reports whether nonce was recorded and has not expired yet, and
+// records it otherwise.
+func (s *MemoryNonceStore) Seen(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.After(s.sweep) {
+		for n, expires := range s.nonces {
+			if now.After(expires) {
+				delete(s.nonces, n)
+			}
+		}
+		s.sweep = now.Add(time.Minute)
+	}
+
+	if expires, ok := s.nonces[nonce]; ok && now.Before(expires) {
+		return true, nil
+	}
+	s.nonces[nonce] = now.Add(ttl)
+	return false, nil
+}
+
+// WebhookConfig defines the configuration options for the VerifyWebhook middleware.
+type WebhookConfig struct {
+	// Secrets are the shared secrets a signature may be made with. A request
+	// is accepted if any of them verifies, so a new secret can be added
+	// before the provider switches to it.
+	Secrets [][]byte
+
+	// Scheme describes the provider's signature format.
+	Scheme WebhookScheme
+
+	// Tolerance is the largest accepted difference between the signed
+	// timestamp and the current time. Deliveries of timestamped schemes are
+	// remembered until their timestamp can no longer be accepted, and at
+	// least twice Tolerance. Schemes without a timestamp are only protected
+	// against replays for Tolerance after a delivery is accepted. Default
+	// value is 5 minutes.
+	Tolerance time.Duration
+
+	// Nonces remembers accepted deliveries so they cannot be replayed.
+	// Default value is a MemoryNonceStore.
+	Nonces NonceStore
+
+	// MaxBodyBytes is the largest body that is read and verified. Larger
+	// bodies are rejected with 413. Default is 1 MiB.
+	MaxBodyBytes int64
+}
+
+// DefaultWebhookConfig returns a webhook configuration with common settings.
+// The default configuration:
+// - Accepts timestamps up to 5 minutes away from the current time
+// - Remembers deliveries in memory to reject replays
+// - Reads bodies up to 1 MiB
+func DefaultWebhookConfig() WebhookConfig {
+	return WebhookConfig{
+		Tolerance:    5 * time.Minute,
+		Nonces:       NewMemoryNonceStore(),
+		MaxBodyBytes: 1 << 20,
+	}
+}
+
+// VerifyWebhook returns a middleware that verifies the HMAC-SHA256 signature
+// of webhook requests. The body is read in full and verified before the
+// handler runs, then restored so the handler can read it as usual. Requests
+// with a missing or invalid signature, a timestamp outside the tolerance
+// window, or a delivery that was already accepted are rejected with 401
+// Unauthorized.
+//
+// Parameters:
+//   - config: The WebhookConfig struct containing the verification configuration
+//
+// Returns:
+//   - A MiddleWare function that can be applied to HTTP handlers
+func VerifyWebhook(config WebhookConfig) MiddleWare {
+	if config.Scheme.Extract == nil || config.Scheme.Payload == nil {
+		panic("intake: VerifyWebhook requires a Scheme")
+	}
+	defaults := DefaultWebhookConfig()
+	if config.Tolerance <= 0 {
+		config.Tolerance = defaults.Tolerance
+	}
+	if config.Nonces == nil {
+		config.Nonces = defaults.Nonces
+	}
+	if config.MaxBodyBytes <= 0 {
+		config.MaxBodyBytes = defaults.MaxBodyBytes
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(io.LimitReader(r.Body, config.MaxBodyBytes+1))
+			if err != nil {
+				http.Error(w, "failed to read request body", http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > config.MaxBodyBytes {
+				respondTooLarge(w)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			timestamp, nonce, signatures, err := config.Scheme.Extract(r)
+			if err != nil || len(signatures) == 0 {
+				http.Error(w, "missing or malformed signature", http.StatusUnauthorized)
+				return
+			}
+
+			ttl := config.Tolerance
+			if timestamp != "" {
+				seconds, err := strconv.ParseInt(timestamp, 10, 64)
+				if err != nil {
+					http.Error(w, "malformed signature timestamp", http.StatusUnauthorized)
+					return
+				}
+				signed := time.Unix(seconds, 0)
+				if skew := time.Since(signed).Abs(); skew > config.Tolerance {
+					http.Error(w, "signature timestamp outside tolerance", http.StatusUnauthorized)
+					return
+				}
+				// A timestamp ahead of the clock stays acceptable for longer
+				// than Tolerance, so the delivery must be remembered until
+				// it is rejected by the check above.
+				ttl = max(time.Until(signed.Add(config.Tolerance)), 2*config.Tolerance)
+			}
+
+			signature := matchWebhookSignature(config.Secrets, config.Scheme.Payload(timestamp, body), signatures)
+			if signature == nil {
+				http.Error(w, "invalid signature", http.StatusUnauthorized)
+				return
+			}
+
+			// Only verified deliveries are remembered, so unsigned requests
+			// cannot fill the nonce store or block a genuine delivery.
+			if nonce == "" {
+				nonce = timestamp + "." + hex.EncodeToString(signature)
+			}
+			seen, err := config.Nonces.Seen(r.Context(), nonce, ttl)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if seen {
+				http.Error(w, "webhook delivery was already processed", http.StatusUnauthorized)
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
+
+// matchWebhookSignature returns the first of signatures that is a valid
+// HMAC-SHA256 of payload under any of secrets, or nil if none is.
+func matchWebhookSignature(secrets [][]byte, payload []byte, signatures [][]byte) []byte {
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(payload)
+		expected := mac.Sum(nil)
+		for _, sig := range signatures {
+			if hmac.Equal(expected, sig) {
+				return sig
+			}
+		}
+	}
+	return nil
+}
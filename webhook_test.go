@@ -0,0 +1,139 @@
+package intake
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func webhookMAC(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}
+
+	app := New()
+	app.AddEndpoint(http.MethodPost, "/timestamped", echo, VerifyWebhook(WebhookConfig{
+		Secrets: [][]byte{[]byte("new-secret"), []byte("old-secret")},
+		Scheme:  TimestampedWebhookScheme("Webhook-Signature"),
+	}))
+	app.AddEndpoint(http.MethodPost, "/hex", echo, VerifyWebhook(WebhookConfig{
+		Secrets: [][]byte{[]byte("hex-secret")},
+		Scheme:  HexWebhookScheme("X-Hub-Signature-256", "sha256=", ""),
+	}))
+
+	call := func(path, header, value, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(header, value)
+		rr := httptest.NewRecorder()
+		app.Mux.ServeHTTP(rr, req)
+		return rr
+	}
+	timestamped := func(ts time.Time, secret, body string) string {
+		t := strconv.FormatInt(ts.Unix(), 10)
+		return "t=" + t + ",v1=" + webhookMAC(secret, t+"."+body)
+	}
+
+	const body = `{"event":"paid"}`
+
+	t.Run("timestamped scheme", func(t *testing.T) {
+		now := time.Now()
+		rr := call("/timestamped", "Webhook-Signature", timestamped(now, "old-secret", body), body)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d (%s)", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		if rr.Body.String() != body {
+			t.Fatalf("expected handler to read the body, got %q", rr.Body.String())
+		}
+
+		// Several signatures may be present while the provider rotates.
+		header := timestamped(now.Add(time.Second), "unknown", body) + ",v1=" +
+			webhookMAC("new-secret", strconv.FormatInt(now.Add(time.Second).Unix(), 10)+"."+body)
+		if rr := call("/timestamped", "Webhook-Signature", header, body); rr.Code != http.StatusOK {
+			t.Fatalf("expected rotated signature to verify, got %d", rr.Code)
+		}
+	})
+
+	t.Run("rejects replays", func(t *testing.T) {
+		header := timestamped(time.Now().Add(-time.Minute), "new-secret", body)
+		if rr := call("/timestamped", "Webhook-Signature", header, body); rr.Code != http.StatusOK {
+			t.Fatalf("expected first delivery to succeed, got %d", rr.Code)
+		}
+		if rr := call("/timestamped", "Webhook-Signature", header, body); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected replay to be rejected, got %d", rr.Code)
+		}
+	})
+
+	t.Run("rejects invalid deliveries", func(t *testing.T) {
+		cases := map[string]string{
+			"stale":         timestamped(time.Now().Add(-10*time.Minute), "new-secret", body),
+			"future":        timestamped(time.Now().Add(10*time.Minute), "new-secret", body),
+			"wrong secret":  timestamped(time.Now(), "wrong", body),
+			"tampered body": timestamped(time.Now(), "new-secret", `{"event":"refunded"}`),
+			"no timestamp":  "v1=" + webhookMAC("new-secret", body),
+			"missing":       "",
+		}
+		for name, header := range cases {
+			if rr := call("/timestamped", "Webhook-Signature", header, body); rr.Code != http.StatusUnauthorized {
+				t.Fatalf("%s: expected status %d, got %d", name, http.StatusUnauthorized, rr.Code)
+			}
+		}
+	})
+
+	t.Run("hex scheme", func(t *testing.T) {
+		rr := call("/hex", "X-Hub-Signature-256", "sha256="+webhookMAC("hex-secret", body), body)
+		if rr.Code != http.StatusOK || rr.Body.String() != body {
+			t.Fatalf("expected status %d with body, got %d %q", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		if rr := call("/hex", "X-Hub-Signature-256", webhookMAC("hex-secret", body), body); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected missing prefix to be rejected, got %d", rr.Code)
+		}
+	})
+
+	t.Run("remembers future timestamps until they expire", func(t *testing.T) {
+		store := &ttlNonceStore{}
+		tolerance := 5 * time.Minute
+		verify := VerifyWebhook(WebhookConfig{
+			Secrets:   [][]byte{[]byte("secret")},
+			Scheme:    TimestampedWebhookScheme("Webhook-Signature"),
+			Tolerance: tolerance,
+			Nonces:    store,
+		})(echo)
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Webhook-Signature", timestamped(time.Now().Add(4*time.Minute), "secret", body))
+		rr := httptest.NewRecorder()
+		verify(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+		}
+		// The timestamp is accepted for another 9 minutes, so the nonce
+		// must outlive it.
+		if store.ttl < 9*time.Minute {
+			t.Fatalf("expected nonce to be kept for at least 9m, got %v", store.ttl)
+		}
+	})
+}
+
+// ttlNonceStore records the TTL of the last delivery it saw.
+type ttlNonceStore struct {
+	ttl time.Duration
+}
+
+func (s *ttlNonceStore) Seen(_ context.Context, _ string, ttl time.Duration) (bool, error) {
+	s.ttl = ttl
+	return false, nil
+}